@@ -19,60 +19,44 @@ package aws
  */
 
 import (
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"strings"
 	"time"
 
 	"github.com/antchfx/xmlquery"
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/sts"
 	"github.com/davecgh/go-spew/spew"
 	log "github.com/sirupsen/logrus"
 )
 
 const (
-	XML_QUERY_ROLES   = "/samlp:Response/saml:Assertion/saml:AttributeStatement/saml:Attribute/saml:AttributeValue"
 	XML_QUERY_EXPIRES = "/samlp:Response/saml:Assertion/saml:Conditions/@NotOnOrAfter"
 )
 
 // get list of role ARNs in a SAML Assertion
 func GetRoles(assertion string) ([]string, error) {
 	roles := []string{}
-	reader := strings.NewReader(assertion)
-	q, err := xmlquery.Parse(reader)
+	attrs, err := ParseSAMLAttributes(assertion)
 	if err != nil {
 		return roles, err
 	}
-	// returns our Roles as well as our Email address
-	items := xmlquery.Find(q, XML_QUERY_ROLES)
-	for _, item := range items {
-		if strings.HasPrefix(item.InnerText(), "arn:aws:iam:") {
-			splits := strings.Split(item.InnerText(), ",")
-			roles = append(roles, splits[0])
-		}
+	for _, role := range attrs.Roles {
+		roles = append(roles, role.RoleARN)
 	}
 	return roles, nil
 }
 
 // get the ARN of the provided role ARN from the saml assertion
 func GetRolePrincipalARN(assertion string, role string) (string, error) {
-	reader := strings.NewReader(assertion)
-	q, err := xmlquery.Parse(reader)
+	attrs, err := ParseSAMLAttributes(assertion)
 	if err != nil {
 		return "", err
 	}
-	// returns our Roles as well as our Email address
-	items := xmlquery.Find(q, XML_QUERY_ROLES)
-	search_role := fmt.Sprintf("%s,", role)
-	for _, item := range items {
-		if strings.HasPrefix(item.InnerText(), search_role) {
-			splits := strings.Split(item.InnerText(), ",")
-			return splits[1], nil
-		}
-	}
-	return "", fmt.Errorf("Unable to find the role '%s' in SAML assertion", role)
+	return attrs.GetPrincipalARN(role)
 }
 
 // When does this SAML expire?
@@ -95,26 +79,72 @@ func GetExpireTime(assertion string) (*time.Time, error) {
 	return nil, fmt.Errorf("Unable to locate NotOnOrAfter time in SAML Assertion")
 }
 
-func GetSTSSession(assertion string, role string, region string, duration int64) (STSSession, error) {
+/*
+ * Further restricts the permissions of an STS session.  The session has only
+ * the permissions allowed by both the role and these policies.
+ */
+type SessionPolicy struct {
+	Policy     string   // inline JSON policy
+	PolicyArns []string // ARNs of managed policies
+}
+
+// Sets the session policy of an AssumeRole* request
+func (sp SessionPolicy) apply(policy **string, arns *[]*sts.PolicyDescriptorType) {
+	if sp.Policy != "" {
+		*policy = aws.String(sp.Policy)
+	}
+	for _, arn := range sp.PolicyArns {
+		*arns = append(*arns, &sts.PolicyDescriptorType{Arn: aws.String(arn)})
+	}
+}
+
+/*
+ * Identifies the policy a cached session was created with so we don't reuse
+ * it after the policy changes.  Empty if there is no session policy.
+ */
+func (sp SessionPolicy) Hash() string {
+	if sp.Policy == "" && len(sp.PolicyArns) == 0 {
+		return ""
+	}
+	h := sha256.New()
+	h.Write([]byte(sp.Policy))
+	for _, arn := range sp.PolicyArns {
+		h.Write([]byte("\n" + arn))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Calls AssumeRoleWithSAML.  If opts.Client is nil, the default http.Client is used
+func GetSTSSession(assertion string, opts STSOptions) (STSSession, error) {
 	ret := STSSession{}
-	principal, err := GetRolePrincipalARN(assertion, role)
+	attrs, err := ParseSAMLAttributes(assertion)
+	if err != nil {
+		return ret, err
+	}
+	principal, err := attrs.GetPrincipalARN(opts.RoleARN)
 	if err != nil {
 		return ret, err
 	}
+	duration := opts.Duration
+	if d := attrs.GetDuration(duration); d != duration {
+		log.Warnf("Requested session duration of %ds exceeds the SessionDuration of %ds in the SAML assertion, using %ds",
+			duration, attrs.SessionDuration, d)
+		duration = d
+	}
 	saml := base64.StdEncoding.EncodeToString([]byte(assertion))
 
-	s, err := session.NewSession()
+	svc, region, err := newSTSClient(opts, nil)
 	if err != nil {
 		return ret, err
 	}
-	svc := sts.New(s, aws.NewConfig().WithRegion(region))
 	input := sts.AssumeRoleWithSAMLInput{
 		DurationSeconds: &duration,
 		PrincipalArn:    &principal,
-		RoleArn:         &role,
+		RoleArn:         &opts.RoleARN,
 		SAMLAssertion:   &saml,
 	}
-	output, err := svc.AssumeRoleWithSAML(&input)
+	opts.Policy.apply(&input.Policy, &input.PolicyArns)
+	output, err := svc.AssumeRoleWithSAMLWithContext(opts.context(), &input)
 	if err != nil {
 		return ret, err
 	}
@@ -122,12 +152,14 @@ func GetSTSSession(assertion string, role string, region string, duration int64)
 	creds := output.Credentials
 
 	ret = STSSession{
-		RoleARN:         role,
+		RoleARN:         opts.RoleARN,
 		AccessKeyID:     *creds.AccessKeyId,
 		SecretAccessKey: *creds.SecretAccessKey,
 		SessionToken:    *creds.SessionToken,
 		Expiration:      *creds.Expiration,
 		Issuer:          *output.Issuer,
+		RoleSessionName: attrs.RoleSessionName,
+		PolicyHash:      opts.Policy.Hash(),
 		Region:          region,
 	}
 	log.Debugf("STSSession = %s", spew.Sdump(ret))
@@ -0,0 +1,95 @@
+package aws
+
+/*
+ * OneLogin AWS Role
+ * Copyright (c) 2020-2021 Aaron Turner  <aturner at synfin dot net>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+/*
+ * Role chaining: use the STS session of one role to AssumeRole into another,
+ * like the AWS CLI does for profiles with a `source_profile`.
+ */
+
+import (
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/davecgh/go-spew/spew"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// AWS limits sessions from role chaining to 1 hour
+	ROLE_CHAINING_MAX_DURATION = 3600
+	DEFAULT_ROLE_SESSION_NAME  = "onelogin-aws-role"
+)
+
+type AssumeRoleOptions struct {
+	STSOptions
+	ExternalId  string // optional
+	SessionName string // defaults to the RoleSessionName of the source session
+}
+
+// Calls AssumeRole using the credentials of the source session
+func AssumeRole(source STSSession, opts AssumeRoleOptions) (STSSession, error) {
+	ret := STSSession{}
+	duration := opts.Duration
+	if duration > ROLE_CHAINING_MAX_DURATION {
+		log.Warnf("Requested session duration of %ds exceeds the %ds limit for role chaining, using %ds",
+			duration, ROLE_CHAINING_MAX_DURATION, ROLE_CHAINING_MAX_DURATION)
+		duration = ROLE_CHAINING_MAX_DURATION
+	}
+
+	name := opts.SessionName
+	if name == "" {
+		name = source.RoleSessionName
+	}
+	if name == "" {
+		name = DEFAULT_ROLE_SESSION_NAME
+	}
+
+	creds := credentials.NewStaticCredentials(source.AccessKeyID, source.SecretAccessKey, source.SessionToken)
+	svc, region, err := newSTSClient(opts.STSOptions, creds)
+	if err != nil {
+		return ret, err
+	}
+	input := sts.AssumeRoleInput{
+		DurationSeconds: &duration,
+		RoleArn:         &opts.RoleARN,
+		RoleSessionName: &name,
+	}
+	if opts.ExternalId != "" {
+		input.ExternalId = &opts.ExternalId
+	}
+	opts.Policy.apply(&input.Policy, &input.PolicyArns)
+	output, err := svc.AssumeRoleWithContext(opts.context(), &input)
+	if err != nil {
+		return ret, err
+	}
+
+	ret = STSSession{
+		RoleARN:         opts.RoleARN,
+		AccessKeyID:     *output.Credentials.AccessKeyId,
+		SecretAccessKey: *output.Credentials.SecretAccessKey,
+		SessionToken:    *output.Credentials.SessionToken,
+		Expiration:      *output.Credentials.Expiration,
+		Issuer:          source.Issuer,
+		RoleSessionName: name,
+		PolicyHash:      opts.Policy.Hash(),
+		Region:          region,
+	}
+	log.Debugf("STSSession = %s", spew.Sdump(ret))
+	return ret, nil
+}
@@ -0,0 +1,138 @@
+package aws
+
+/*
+ * OneLogin AWS Role
+ * Copyright (c) 2020-2021 Aaron Turner  <aturner at synfin dot net>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+/*
+ * Which STS endpoint we talk to.  By default the SDK uses the global
+ * sts.amazonaws.com endpoint for most regions, which doesn't work on
+ * hosts which can only reach STS via a VPC interface endpoint.
+ */
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+	log "github.com/sirupsen/logrus"
+)
+
+type STSEndpoint struct {
+	Endpoint    string // URL of STS, overrides UseRegional and UseFIPS
+	UseRegional bool   // use sts.<region>.amazonaws.com instead of the global endpoint
+	UseFIPS     bool   // use the FIPS 140-2 validated endpoint for the region
+}
+
+// Common options for our STS calls
+type STSOptions struct {
+	RoleARN  string
+	Region   string
+	Duration int64           // seconds
+	Policy   SessionPolicy   // optional
+	Endpoint STSEndpoint     // optional
+	Client   *http.Client    // optional
+	Context  context.Context // optional, for cancelling the request
+}
+
+func (opts STSOptions) context() context.Context {
+	if opts.Context == nil {
+		return context.Background()
+	}
+	return opts.Context
+}
+
+/*
+ * Returns the URL of the STS endpoint to use for the region or an empty
+ * string to use the SDK default.
+ */
+func (e STSEndpoint) URL(partition Partition, region string) (string, error) {
+	if e.Endpoint != "" {
+		endpoint := e.Endpoint
+		if !strings.Contains(endpoint, "://") {
+			endpoint = "https://" + endpoint
+		}
+		u, err := url.Parse(endpoint)
+		if err != nil || u.Host == "" {
+			return "", fmt.Errorf("Invalid STS endpoint: %s", e.Endpoint)
+		}
+		return endpoint, nil
+	}
+
+	if e.UseFIPS {
+		switch partition.Name {
+		case "aws":
+			if !strings.HasPrefix(region, "us-") {
+				return "", fmt.Errorf("STS has no FIPS endpoint in %s", region)
+			}
+			return fmt.Sprintf("https://sts-fips.%s.%s", region, partition.DNSSuffix), nil
+		case "aws-us-gov":
+			// the GovCloud regional endpoints are already FIPS validated
+			return fmt.Sprintf("https://sts.%s.%s", region, partition.DNSSuffix), nil
+		default:
+			return "", fmt.Errorf("STS has no FIPS endpoints in the %s partition", partition.Name)
+		}
+	}
+
+	if e.UseRegional {
+		return fmt.Sprintf("https://sts.%s.%s", region, partition.DNSSuffix), nil
+	}
+	return "", nil
+}
+
+/*
+ * Returns an STS client for the role's partition along with the region used.
+ * If creds is nil, the request is not signed as for AssumeRoleWithSAML.
+ */
+func newSTSClient(opts STSOptions, creds *credentials.Credentials) (*sts.STS, string, error) {
+	partition, err := PartitionFromARN(opts.RoleARN)
+	if err != nil {
+		return nil, "", err
+	}
+	region := opts.Region
+	if r := partition.STSRegion(region); r != region {
+		log.Warnf("Region %s is not in the %s partition, using %s", region, partition.Name, r)
+		region = r
+	}
+	endpoint, err := opts.Endpoint.URL(partition, region)
+	if err != nil {
+		return nil, "", err
+	}
+
+	s, err := session.NewSession()
+	if err != nil {
+		return nil, "", err
+	}
+	config := aws.NewConfig().WithRegion(region)
+	if endpoint != "" {
+		log.Debugf("Using STS endpoint %s", endpoint)
+		config = config.WithEndpoint(endpoint)
+	}
+	if creds != nil {
+		config = config.WithCredentials(creds)
+	}
+	if opts.Client != nil {
+		config = config.WithHTTPClient(opts.Client)
+	}
+	return sts.New(s, config), region, nil
+}
@@ -0,0 +1,74 @@
+package aws
+
+/*
+ * OneLogin AWS Role
+ * Copyright (c) 2020-2021 Aaron Turner  <aturner at synfin dot net>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"testing"
+)
+
+func TestSTSEndpointURL(t *testing.T) {
+	tests := []struct {
+		name      string
+		endpoint  STSEndpoint
+		partition string
+		region    string
+		url       string
+		err       bool
+	}{
+		{"global", STSEndpoint{}, "aws", "eu-west-1", "", false},
+		{"global aws-cn", STSEndpoint{}, "aws-cn", "cn-north-1", "", false},
+		{"global aws-us-gov", STSEndpoint{}, "aws-us-gov", "us-gov-west-1", "", false},
+		{"regional", STSEndpoint{UseRegional: true}, "aws", "eu-west-1", "https://sts.eu-west-1.amazonaws.com", false},
+		{"regional aws-cn", STSEndpoint{UseRegional: true}, "aws-cn", "cn-northwest-1",
+			"https://sts.cn-northwest-1.amazonaws.com.cn", false},
+		{"regional aws-us-gov", STSEndpoint{UseRegional: true}, "aws-us-gov", "us-gov-east-1",
+			"https://sts.us-gov-east-1.amazonaws.com", false},
+		{"fips", STSEndpoint{UseFIPS: true}, "aws", "us-east-1", "https://sts-fips.us-east-1.amazonaws.com", false},
+		{"fips overrides regional", STSEndpoint{UseFIPS: true, UseRegional: true}, "aws", "us-west-2",
+			"https://sts-fips.us-west-2.amazonaws.com", false},
+		{"fips aws-us-gov", STSEndpoint{UseFIPS: true}, "aws-us-gov", "us-gov-west-1",
+			"https://sts.us-gov-west-1.amazonaws.com", false},
+		{"no fips outside the US", STSEndpoint{UseFIPS: true}, "aws", "eu-west-1", "", true},
+		{"no fips in aws-cn", STSEndpoint{UseFIPS: true}, "aws-cn", "cn-north-1", "", true},
+		{"custom", STSEndpoint{Endpoint: "https://sts.example.com"}, "aws", "us-east-1", "https://sts.example.com", false},
+		{"custom without scheme", STSEndpoint{Endpoint: "vpce-1234.sts.us-east-1.vpce.amazonaws.com"}, "aws", "us-east-1",
+			"https://vpce-1234.sts.us-east-1.vpce.amazonaws.com", false},
+		{"custom overrides fips", STSEndpoint{Endpoint: "https://sts.example.com:8443", UseFIPS: true, UseRegional: true},
+			"aws-cn", "cn-north-1", "https://sts.example.com:8443", false},
+		{"custom without host", STSEndpoint{Endpoint: "https://"}, "aws", "us-east-1", "", true},
+		{"custom invalid", STSEndpoint{Endpoint: "https://sts example.com"}, "aws", "us-east-1", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			url, err := tt.endpoint.URL(Partitions[tt.partition], tt.region)
+			if tt.err {
+				if err == nil {
+					t.Errorf("URL() = %s, want an error", url)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("URL(): %s", err)
+			}
+			if url != tt.url {
+				t.Errorf("URL() = %q, want %q", url, tt.url)
+			}
+		})
+	}
+}
@@ -0,0 +1,179 @@
+package aws
+
+/*
+ * OneLogin AWS Role
+ * Copyright (c) 2020-2021 Aaron Turner  <aturner at synfin dot net>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+/*
+ * Decodes a SAML assertion into something humans can read when
+ * troubleshooting why a role isn't available or STS rejects it.
+ */
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/antchfx/xmlquery"
+)
+
+const (
+	XML_QUERY_ASSERTION      = "//*[local-name()='Assertion']"
+	XML_QUERY_ISSUER         = "./*[local-name()='Issuer']"
+	XML_QUERY_NAMEID         = "./*[local-name()='Subject']/*[local-name()='NameID']"
+	XML_QUERY_CONFIRMATION   = "./*[local-name()='Subject']/*[local-name()='SubjectConfirmation']/*[local-name()='SubjectConfirmationData']"
+	XML_QUERY_CONDITIONS     = "./*[local-name()='Conditions']"
+	XML_QUERY_AUDIENCE       = "./*[local-name()='AudienceRestriction']/*[local-name()='Audience']"
+	XML_QUERY_SIGNATURE      = "./*[local-name()='Signature']"
+	XML_QUERY_RESPONSE       = "/*[local-name()='Response']"
+	XML_QUERY_AUTH_STATEMENT = "./*[local-name()='AuthnStatement']"
+)
+
+type SAMLAttribute struct {
+	Name   string   `json:"name"`
+	Values []string `json:"values"`
+}
+
+// What we know about a SAML assertion
+type AssertionInfo struct {
+	Issuer          string          `json:"issuer"`
+	Subject         string          `json:"subject"`
+	SubjectFormat   string          `json:"subject_format,omitempty"`
+	Recipient       string          `json:"recipient,omitempty"`
+	Audiences       []string        `json:"audiences"`
+	IssueInstant    *time.Time      `json:"issue_instant,omitempty"`
+	NotBefore       *time.Time      `json:"not_before,omitempty"`
+	NotOnOrAfter    *time.Time      `json:"not_on_or_after,omitempty"`
+	SessionNotAfter *time.Time      `json:"session_not_on_or_after,omitempty"`
+	ResponseSigned  bool            `json:"response_signed"`
+	AssertionSigned bool            `json:"assertion_signed"`
+	Attributes      []SAMLAttribute `json:"attributes"`
+	Roles           []SAMLRole      `json:"roles"`
+	RoleErrors      []string        `json:"role_errors,omitempty"` // malformed Role values
+	RoleSessionName string          `json:"role_session_name,omitempty"`
+	SessionDuration int64           `json:"session_duration,omitempty"`
+	Errors          []string        `json:"errors,omitempty"` // other values we couldn't parse
+}
+
+/*
+ * Returns the XML of a SAML assertion which may be base64 encoded as
+ * it is in the SAMLResponse form value the IdP POSTs to AWS.
+ */
+func DecodeAssertion(data []byte) (string, error) {
+	s := strings.TrimSpace(string(data))
+	s = strings.TrimPrefix(s, "SAMLResponse=")
+	if strings.HasPrefix(s, "<") {
+		return s, nil
+	}
+
+	// base64 may be wrapped across multiple lines
+	s = strings.Join(strings.Fields(s), "")
+	for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.URLEncoding, base64.RawStdEncoding} {
+		xml, err := enc.DecodeString(s)
+		if err == nil && strings.HasPrefix(strings.TrimSpace(string(xml)), "<") {
+			return strings.TrimSpace(string(xml)), nil
+		}
+	}
+	return "", fmt.Errorf("SAML assertion is neither XML nor base64 encoded XML")
+}
+
+/*
+ * Returns the issuer, subject, conditions and attributes of a SAML assertion.
+ * Values which we can't parse are reported in RoleErrors and Errors so the
+ * rest of the assertion can still be inspected.
+ */
+func InspectAssertion(assertion string) (*AssertionInfo, error) {
+	q, err := xmlquery.Parse(strings.NewReader(assertion))
+	if err != nil {
+		return nil, err
+	}
+	a := xmlquery.FindOne(q, XML_QUERY_ASSERTION)
+	if a == nil {
+		return nil, fmt.Errorf("Unable to locate Assertion in SAML Response")
+	}
+
+	info := AssertionInfo{
+		Audiences:  []string{},
+		Attributes: []SAMLAttribute{},
+	}
+	if r := xmlquery.FindOne(q, XML_QUERY_RESPONSE); r != nil {
+		info.ResponseSigned = xmlquery.FindOne(r, XML_QUERY_SIGNATURE) != nil
+	}
+	info.AssertionSigned = xmlquery.FindOne(a, XML_QUERY_SIGNATURE) != nil
+
+	if issuer := xmlquery.FindOne(a, XML_QUERY_ISSUER); issuer != nil {
+		info.Issuer = strings.TrimSpace(issuer.InnerText())
+	}
+	info.IssueInstant = info.parseTime(a, "IssueInstant")
+	if nameid := xmlquery.FindOne(a, XML_QUERY_NAMEID); nameid != nil {
+		info.Subject = strings.TrimSpace(nameid.InnerText())
+		info.SubjectFormat = nameid.SelectAttr("Format")
+	}
+	if confirm := xmlquery.FindOne(a, XML_QUERY_CONFIRMATION); confirm != nil {
+		info.Recipient = confirm.SelectAttr("Recipient")
+	}
+
+	if conditions := xmlquery.FindOne(a, XML_QUERY_CONDITIONS); conditions != nil {
+		for _, audience := range xmlquery.Find(conditions, XML_QUERY_AUDIENCE) {
+			info.Audiences = append(info.Audiences, strings.TrimSpace(audience.InnerText()))
+		}
+		info.NotBefore = info.parseTime(conditions, "NotBefore")
+		info.NotOnOrAfter = info.parseTime(conditions, "NotOnOrAfter")
+	}
+	if authn := xmlquery.FindOne(a, XML_QUERY_AUTH_STATEMENT); authn != nil {
+		info.SessionNotAfter = info.parseTime(authn, "SessionNotOnOrAfter")
+	}
+
+	attrs, err := readSAMLAttributes(q)
+	if err != nil {
+		info.Errors = append(info.Errors, err.Error())
+	}
+	for _, name := range attrs.Order {
+		info.Attributes = append(info.Attributes, SAMLAttribute{
+			Name:   name,
+			Values: attrs.Attributes[name],
+		})
+	}
+	info.Roles = attrs.Roles
+	info.RoleErrors = attrs.RoleErrors
+	info.RoleSessionName = attrs.RoleSessionName
+	info.SessionDuration = attrs.SessionDuration
+	return &info, nil
+}
+
+// Like parseSAMLTime, but records the error instead of returning it
+func (info *AssertionInfo) parseTime(node *xmlquery.Node, attr string) *time.Time {
+	t, err := parseSAMLTime(node, attr)
+	if err != nil {
+		info.Errors = append(info.Errors, err.Error())
+	}
+	return t
+}
+
+// Returns the time in the given XML attribute or nil if it isn't set
+func parseSAMLTime(node *xmlquery.Node, attr string) (*time.Time, error) {
+	value := node.SelectAttr(attr)
+	if value == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse %s %s: %s", attr, value, err.Error())
+	}
+	return &t, nil
+}
@@ -0,0 +1,112 @@
+package aws
+
+/*
+ * OneLogin AWS Role
+ * Copyright (c) 2020-2021 Aaron Turner  <aturner at synfin dot net>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+/*
+ * AWS partitions: the commercial regions, China and GovCloud each have their
+ * own ARN prefix, STS endpoints and SAML sign-in URL.
+ */
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+)
+
+type Partition struct {
+	Name          string // as used in ARNs
+	DefaultRegion string // region to use for STS if none is specified
+	SignInURL     string // SAML endpoint for the AWS Console
+	DNSSuffix     string // of the service endpoints
+}
+
+var Partitions = map[string]Partition{
+	"aws": {
+		Name:          "aws",
+		DefaultRegion: "us-east-1",
+		SignInURL:     "https://signin.aws.amazon.com/saml",
+		DNSSuffix:     "amazonaws.com",
+	},
+	"aws-cn": {
+		Name:          "aws-cn",
+		DefaultRegion: "cn-north-1",
+		SignInURL:     "https://signin.amazonaws.cn/saml",
+		DNSSuffix:     "amazonaws.com.cn",
+	},
+	"aws-us-gov": {
+		Name:          "aws-us-gov",
+		DefaultRegion: "us-gov-west-1",
+		SignInURL:     "https://signin.amazonaws-us-gov.com/saml",
+		DNSSuffix:     "amazonaws.com",
+	},
+}
+
+// Returns the partition for the given ARN
+func PartitionFromARN(arn string) (Partition, error) {
+	fields := strings.SplitN(arn, ":", 3)
+	if len(fields) < 3 || fields[0] != "arn" {
+		return Partition{}, fmt.Errorf("Invalid ARN: %s", arn)
+	}
+	p, ok := Partitions[fields[1]]
+	if !ok {
+		return Partition{}, fmt.Errorf("Unsupported AWS partition '%s' in %s", fields[1], arn)
+	}
+	return p, nil
+}
+
+// Returns true if the string is an IAM ARN in any supported partition
+func IsIAMArn(arn string) bool {
+	for name := range Partitions {
+		if strings.HasPrefix(arn, fmt.Sprintf("arn:%s:iam:", name)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true if the region belongs to this partition
+func (p Partition) HasRegion(region string) bool {
+	if region == "" {
+		return false
+	}
+	part, ok := endpoints.PartitionForRegion(endpoints.DefaultPartitions(), region)
+	if !ok {
+		// unknown to our SDK, so guess based on the name
+		switch p.Name {
+		case "aws-cn":
+			return strings.HasPrefix(region, "cn-")
+		case "aws-us-gov":
+			return strings.HasPrefix(region, "us-gov-")
+		}
+		return !strings.HasPrefix(region, "cn-") && !strings.HasPrefix(region, "us-gov-")
+	}
+	return part.ID() == p.Name
+}
+
+/*
+ * Returns the region to use for STS calls for the role.  STS only accepts
+ * SAML assertions for roles in its own partition.
+ */
+func (p Partition) STSRegion(region string) string {
+	if region == "" || !p.HasRegion(region) {
+		return p.DefaultRegion
+	}
+	return region
+}
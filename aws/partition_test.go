@@ -0,0 +1,109 @@
+package aws
+
+/*
+ * OneLogin AWS Role
+ * Copyright (c) 2020-2021 Aaron Turner  <aturner at synfin dot net>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"testing"
+)
+
+func TestPartitionFromARN(t *testing.T) {
+	tests := []struct {
+		arn       string
+		partition string
+		signin    string
+		err       bool
+	}{
+		{"arn:aws:iam::123456789012:role/Admin", "aws", "https://signin.aws.amazon.com/saml", false},
+		{"arn:aws-cn:iam::123456789012:role/Admin", "aws-cn", "https://signin.amazonaws.cn/saml", false},
+		{"arn:aws-us-gov:iam::123456789012:role/Admin", "aws-us-gov", "https://signin.amazonaws-us-gov.com/saml", false},
+		{"arn:aws:iam::123456789012:saml-provider/OneLogin", "aws", "https://signin.aws.amazon.com/saml", false},
+		{"arn:aws-iso:iam::123456789012:role/Admin", "", "", true},
+		{"arn:aws", "", "", true},
+		{"aws:iam::123456789012:role/Admin", "", "", true},
+		{"", "", "", true},
+	}
+	for _, tt := range tests {
+		p, err := PartitionFromARN(tt.arn)
+		if tt.err {
+			if err == nil {
+				t.Errorf("PartitionFromARN(%q) = %s, want an error", tt.arn, p.Name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("PartitionFromARN(%q): %s", tt.arn, err)
+			continue
+		}
+		if p.Name != tt.partition || p.SignInURL != tt.signin {
+			t.Errorf("PartitionFromARN(%q) = %s %s, want %s %s", tt.arn, p.Name, p.SignInURL, tt.partition, tt.signin)
+		}
+	}
+}
+
+func TestIsIAMArn(t *testing.T) {
+	tests := map[string]bool{
+		"arn:aws:iam::123456789012:role/Admin":        true,
+		"arn:aws-cn:iam::123456789012:role/Admin":     true,
+		"arn:aws-us-gov:iam::123456789012:role/Admin": true,
+		"arn:aws:sts::123456789012:assumed-role/A/b":  false,
+		"arn:aws-iso:iam::123456789012:role/Admin":    false,
+		"arn:aws:iamx::123456789012:role/Admin":       false,
+		"123456789012:role/Admin":                     false,
+		"":                                            false,
+	}
+	for arn, want := range tests {
+		if got := IsIAMArn(arn); got != want {
+			t.Errorf("IsIAMArn(%q) = %v, want %v", arn, got, want)
+		}
+	}
+}
+
+func TestPartitionRegions(t *testing.T) {
+	tests := []struct {
+		partition string
+		region    string
+		has       bool
+		sts       string
+	}{
+		{"aws", "us-west-2", true, "us-west-2"},
+		{"aws", "eu-central-1", true, "eu-central-1"},
+		{"aws", "cn-north-1", false, "us-east-1"},
+		{"aws", "us-gov-west-1", false, "us-east-1"},
+		{"aws", "", false, "us-east-1"},
+		{"aws-cn", "cn-northwest-1", true, "cn-northwest-1"},
+		{"aws-cn", "us-east-1", false, "cn-north-1"},
+		{"aws-cn", "", false, "cn-north-1"},
+		{"aws-us-gov", "us-gov-east-1", true, "us-gov-east-1"},
+		{"aws-us-gov", "us-east-1", false, "us-gov-west-1"},
+		{"aws-us-gov", "", false, "us-gov-west-1"},
+		// unknown to the SDK, so we guess based on the name
+		{"aws", "xx-future-1", true, "xx-future-1"},
+		{"aws-cn", "xx-future-1", false, "cn-north-1"},
+		{"aws-us-gov", "xx-future-1", false, "us-gov-west-1"},
+	}
+	for _, tt := range tests {
+		p := Partitions[tt.partition]
+		if got := p.HasRegion(tt.region); got != tt.has {
+			t.Errorf("%s.HasRegion(%q) = %v, want %v", tt.partition, tt.region, got, tt.has)
+		}
+		if got := p.STSRegion(tt.region); got != tt.sts {
+			t.Errorf("%s.STSRegion(%q) = %s, want %s", tt.partition, tt.region, got, tt.sts)
+		}
+	}
+}
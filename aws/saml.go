@@ -0,0 +1,181 @@
+package aws
+
+/*
+ * OneLogin AWS Role
+ * Copyright (c) 2020-2021 Aaron Turner  <aturner at synfin dot net>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+/*
+ * Parses the AWS specific attributes in a SAML assertion by name.
+ * See: https://docs.aws.amazon.com/IAM/latest/UserGuide/id_roles_providers_create_saml_assertions.html
+ */
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/antchfx/xmlquery"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	SAML_ATTR_ROLE              = "https://aws.amazon.com/SAML/Attributes/Role"
+	SAML_ATTR_ROLE_SESSION_NAME = "https://aws.amazon.com/SAML/Attributes/RoleSessionName"
+	SAML_ATTR_SESSION_DURATION  = "https://aws.amazon.com/SAML/Attributes/SessionDuration"
+
+	/*
+	 * Match regardless of which XML namespace prefix the IdP uses, but only in
+	 * the top level Assertion since VerifyAssertion() checks its signature.
+	 */
+	XML_QUERY_ATTRIBUTES = "/*[local-name()='Response']/*[local-name()='Assertion']/*[local-name()='AttributeStatement']/*[local-name()='Attribute']"
+	XML_QUERY_VALUES     = "./*[local-name()='AttributeValue']"
+)
+
+// An IAM Role and the SAML provider we can use to assume it
+type SAMLRole struct {
+	RoleARN      string `json:"role_arn"`
+	PrincipalARN string `json:"principal_arn"`
+}
+
+type SAMLAttributes struct {
+	Roles           []SAMLRole
+	RoleErrors      []string // why each malformed Role value was skipped
+	RoleSessionName string
+	SessionDuration int64               // max STS session duration in seconds, 0 if not set
+	Attributes      map[string][]string // all attributes by name
+	Order           []string            // attribute names in the order they appear
+}
+
+// Parses the attributes in a SAML assertion
+func ParseSAMLAttributes(assertion string) (*SAMLAttributes, error) {
+	q, err := xmlquery.Parse(strings.NewReader(assertion))
+	if err != nil {
+		return nil, err
+	}
+	attrs, err := readSAMLAttributes(q)
+	if err != nil {
+		return nil, err
+	}
+
+	// one bad value shouldn't stop us from using the other roles
+	for _, roleErr := range attrs.RoleErrors {
+		log.Warn(roleErr)
+	}
+	if len(attrs.Roles) == 0 && len(attrs.RoleErrors) > 0 {
+		return nil, fmt.Errorf("No valid Role attributes in SAML assertion: %s", strings.Join(attrs.RoleErrors, "; "))
+	}
+	return attrs, nil
+}
+
+/*
+ * Reads the attributes of a parsed SAML assertion.  Malformed Role values
+ * are skipped and listed in RoleErrors.  The attributes are returned even
+ * if the SessionDuration is invalid.
+ */
+func readSAMLAttributes(q *xmlquery.Node) (*SAMLAttributes, error) {
+	var err error
+	attrs := SAMLAttributes{
+		Roles:      []SAMLRole{},
+		RoleErrors: []string{},
+		Attributes: map[string][]string{},
+		Order:      []string{},
+	}
+	for _, attr := range xmlquery.Find(q, XML_QUERY_ATTRIBUTES) {
+		name := attr.SelectAttr("Name")
+		values := []string{}
+		for _, v := range xmlquery.Find(attr, XML_QUERY_VALUES) {
+			values = append(values, strings.TrimSpace(v.InnerText()))
+		}
+		if _, ok := attrs.Attributes[name]; !ok {
+			attrs.Order = append(attrs.Order, name)
+		}
+		attrs.Attributes[name] = append(attrs.Attributes[name], values...)
+	}
+
+	for _, value := range attrs.Attributes[SAML_ATTR_ROLE] {
+		role, err := parseSAMLRole(value)
+		if err != nil {
+			attrs.RoleErrors = append(attrs.RoleErrors, err.Error())
+			continue
+		}
+		attrs.Roles = append(attrs.Roles, role)
+	}
+
+	if values := attrs.Attributes[SAML_ATTR_ROLE_SESSION_NAME]; len(values) > 0 {
+		attrs.RoleSessionName = values[0]
+	}
+
+	if values := attrs.Attributes[SAML_ATTR_SESSION_DURATION]; len(values) > 0 {
+		attrs.SessionDuration, err = strconv.ParseInt(values[0], 10, 64)
+		if err != nil {
+			attrs.SessionDuration = 0
+			return &attrs, fmt.Errorf("Invalid SessionDuration '%s' in SAML assertion", values[0])
+		}
+	}
+	return &attrs, nil
+}
+
+/*
+ * Parses the value of a Role attribute.  AWS allows the role and SAML
+ * provider ARNs to be in either order, so we check which is which.
+ */
+func parseSAMLRole(value string) (SAMLRole, error) {
+	role := SAMLRole{}
+	splits := strings.Split(value, ",")
+	if len(splits) != 2 {
+		return role, fmt.Errorf("Invalid Role attribute '%s' in SAML assertion: expected role and saml-provider ARNs", value)
+	}
+	for _, split := range splits {
+		arn := strings.TrimSpace(split)
+		switch {
+		case isIAMResource(arn, "role") && role.RoleARN == "":
+			role.RoleARN = arn
+		case isIAMResource(arn, "saml-provider") && role.PrincipalARN == "":
+			role.PrincipalARN = arn
+		default:
+			return SAMLRole{}, fmt.Errorf("Invalid Role attribute '%s' in SAML assertion: expected role and saml-provider ARNs", value)
+		}
+	}
+	return role, nil
+}
+
+// Returns true if the ARN is an IAM resource of the given type
+func isIAMResource(arn string, resource string) bool {
+	fields := strings.SplitN(arn, ":", 6)
+	return IsIAMArn(arn) && len(fields) == 6 && strings.HasPrefix(fields[5], resource+"/")
+}
+
+// Returns the principal ARN for the given role
+func (sa *SAMLAttributes) GetPrincipalARN(role string) (string, error) {
+	for _, r := range sa.Roles {
+		if r.RoleARN == role {
+			return r.PrincipalARN, nil
+		}
+	}
+	return "", fmt.Errorf("Unable to find the role '%s' in SAML assertion", role)
+}
+
+/*
+ * Returns the STS session duration to request.  The SessionDuration in the
+ * assertion is the maximum, since STS rejects requests for longer sessions.
+ */
+func (sa *SAMLAttributes) GetDuration(duration int64) int64 {
+	if sa.SessionDuration > 0 && duration > sa.SessionDuration {
+		return sa.SessionDuration
+	}
+	return duration
+}
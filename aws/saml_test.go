@@ -0,0 +1,194 @@
+package aws
+
+/*
+ * OneLogin AWS Role
+ * Copyright (c) 2020-2021 Aaron Turner  <aturner at synfin dot net>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+const (
+	testRoleARN     = "arn:aws:iam::123456789012:role/Admin"
+	testProviderARN = "arn:aws:iam::123456789012:saml-provider/OneLogin"
+)
+
+// Returns a SAML Response with the given attribute values
+func testSAMLResponse(attrs map[string][]string) string {
+	var b strings.Builder
+	b.WriteString(`<samlp:Response xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol">`)
+	b.WriteString(`<saml:Assertion xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion"><saml:AttributeStatement>`)
+	for name, values := range attrs {
+		fmt.Fprintf(&b, `<saml:Attribute Name="%s">`, name)
+		for _, v := range values {
+			fmt.Fprintf(&b, `<saml:AttributeValue>%s</saml:AttributeValue>`, v)
+		}
+		b.WriteString(`</saml:Attribute>`)
+	}
+	b.WriteString(`</saml:AttributeStatement></saml:Assertion></samlp:Response>`)
+	return b.String()
+}
+
+func TestParseSAMLRole(t *testing.T) {
+	want := SAMLRole{RoleARN: testRoleARN, PrincipalARN: testProviderARN}
+	tests := []struct {
+		name  string
+		value string
+		err   bool
+	}{
+		{"role,principal", testRoleARN + "," + testProviderARN, false},
+		{"principal,role", testProviderARN + "," + testRoleARN, false},
+		{"whitespace", "  " + testRoleARN + " ,\n\t" + testProviderARN + "  ", false},
+		{"empty", "", true},
+		{"only a comma", ",", true},
+		{"one ARN", testRoleARN, true},
+		{"three ARNs", testRoleARN + "," + testProviderARN + "," + testRoleARN, true},
+		{"two roles", testRoleARN + "," + testRoleARN, true},
+		{"two providers", testProviderARN + "," + testProviderARN, true},
+		{"not IAM", "arn:aws:s3:::bucket/role/x," + testProviderARN, true},
+		{"unsupported partition", "arn:aws-iso:iam::123456789012:role/Admin," + testProviderARN, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			role, err := parseSAMLRole(tt.value)
+			if tt.err {
+				if err == nil {
+					t.Errorf("parseSAMLRole(%q) = %+v, want an error", tt.value, role)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseSAMLRole(%q): %s", tt.value, err)
+			}
+			if role != want {
+				t.Errorf("parseSAMLRole(%q) = %+v, want %+v", tt.value, role, want)
+			}
+		})
+	}
+}
+
+func TestParseSAMLAttributesRoles(t *testing.T) {
+	other := "arn:aws:iam::123456789012:role/ReadOnly"
+	tests := []struct {
+		name       string
+		values     []string
+		roles      []string
+		roleErrors int
+		err        bool
+	}{
+		{"no roles", nil, []string{}, 0, false},
+		{"both orders", []string{testRoleARN + "," + testProviderARN, testProviderARN + "," + other},
+			[]string{testRoleARN, other}, 0, false},
+		{"skip malformed", []string{"bogus", testRoleARN + "," + testProviderARN, ""},
+			[]string{testRoleARN}, 2, false},
+		{"skip three-part", []string{testRoleARN + "," + testProviderARN + ",extra", other + "," + testProviderARN},
+			[]string{other}, 1, false},
+		{"all malformed", []string{"bogus", ""}, nil, 2, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attrs := map[string][]string{SAML_ATTR_ROLE_SESSION_NAME: {"user@example.com"}}
+			if tt.values != nil {
+				attrs[SAML_ATTR_ROLE] = tt.values
+			}
+			sa, err := ParseSAMLAttributes(testSAMLResponse(attrs))
+			if tt.err {
+				if err == nil {
+					t.Errorf("ParseSAMLAttributes() = %+v, want an error", sa)
+				} else if !strings.Contains(err.Error(), "No valid Role") {
+					t.Errorf("ParseSAMLAttributes() error = %s", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseSAMLAttributes(): %s", err)
+			}
+			roles := []string{}
+			for _, r := range sa.Roles {
+				roles = append(roles, r.RoleARN)
+			}
+			if strings.Join(roles, " ") != strings.Join(tt.roles, " ") {
+				t.Errorf("Roles = %v, want %v", roles, tt.roles)
+			}
+			if len(sa.RoleErrors) != tt.roleErrors {
+				t.Errorf("RoleErrors = %v, want %d errors", sa.RoleErrors, tt.roleErrors)
+			}
+			if sa.RoleSessionName != "user@example.com" {
+				t.Errorf("RoleSessionName = %s", sa.RoleSessionName)
+			}
+		})
+	}
+}
+
+func TestGetDuration(t *testing.T) {
+	tests := []struct {
+		name     string
+		attr     []string
+		request  int64
+		duration int64
+		err      bool
+	}{
+		{"not set", nil, 43200, 43200, false},
+		{"zero", []string{"0"}, 43200, 43200, false},
+		{"shorter request", []string{"7200"}, 3600, 3600, false},
+		{"equal", []string{"7200"}, 7200, 7200, false},
+		{"clamped", []string{"7200"}, 43200, 7200, false},
+		{"invalid", []string{"2h"}, 3600, 0, true},
+		{"empty", []string{""}, 3600, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attrs := map[string][]string{SAML_ATTR_ROLE: {testRoleARN + "," + testProviderARN}}
+			if tt.attr != nil {
+				attrs[SAML_ATTR_SESSION_DURATION] = tt.attr
+			}
+			sa, err := ParseSAMLAttributes(testSAMLResponse(attrs))
+			if tt.err {
+				if err == nil {
+					t.Errorf("ParseSAMLAttributes() with SessionDuration %v succeeded", tt.attr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseSAMLAttributes(): %s", err)
+			}
+			if got := sa.GetDuration(tt.request); got != tt.duration {
+				t.Errorf("GetDuration(%d) = %d, want %d", tt.request, got, tt.duration)
+			}
+		})
+	}
+}
+
+// Only the top level Assertion is covered by VerifyAssertion()
+func TestParseSAMLAttributesNested(t *testing.T) {
+	evil := "arn:aws:iam::123456789012:role/Evil," + testProviderARN
+	xml := strings.Replace(testSAMLResponse(map[string][]string{SAML_ATTR_ROLE: {testRoleARN + "," + testProviderARN}}),
+		"</saml:Assertion>",
+		`</saml:Assertion><samlp:Extensions><saml:Assertion xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion">`+
+			`<saml:AttributeStatement>`+
+			`<saml:Attribute Name="`+SAML_ATTR_ROLE+`"><saml:AttributeValue>`+evil+`</saml:AttributeValue></saml:Attribute>`+
+			`</saml:AttributeStatement></saml:Assertion></samlp:Extensions>`, 1)
+	sa, err := ParseSAMLAttributes(xml)
+	if err != nil {
+		t.Fatalf("ParseSAMLAttributes(): %s", err)
+	}
+	if len(sa.Roles) != 1 || sa.Roles[0].RoleARN != testRoleARN {
+		t.Errorf("Roles = %+v, want only %s", sa.Roles, testRoleARN)
+	}
+}
@@ -31,6 +31,8 @@ type STSSession struct {
 	Expiration      time.Time `json:"AWS_SESSION_EXPIRATION"`
 	Provider        string    `json:"STS_PROVIDER"`
 	Issuer          string    `json:"STS_ISSUER"`
+	RoleSessionName string    `json:"ROLE_SESSION_NAME"`
+	PolicyHash      string    `json:"POLICY_HASH,omitempty"` // SessionPolicy.Hash() of our session policy
 	Region          string    `json:"-"`
 }
 
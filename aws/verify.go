@@ -0,0 +1,235 @@
+package aws
+
+/*
+ * OneLogin AWS Role
+ * Copyright (c) 2020-2021 Aaron Turner  <aturner at synfin dot net>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+/*
+ * Verifies the XML signature, audience and validity window of a SAML assertion
+ * so that a compromised proxy or cache can't give us a forged assertion.
+ */
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/beevik/etree"
+	dsig "github.com/russellhaering/goxmldsig"
+	log "github.com/sirupsen/logrus"
+)
+
+// Audience for SAML assertions sent to AWS
+const SAML_AUDIENCE = "urn:amazon:webservices"
+
+// How much clock skew we allow when checking NotBefore/NotOnOrAfter
+const SAML_CLOCK_SKEW = 1 * time.Minute
+
+var ErrAssertionVerification = errors.New("Unable to verify SAML assertion")
+
+type VerifyOptions struct {
+	Certificates []*x509.Certificate // trusted IdP certificates
+	Fingerprints []string            // SHA-1 or SHA-256 fingerprints of trusted IdP certificates
+	Strict       bool                // fail instead of warn if verification fails
+	RoleARN      string              // optional role we will assume with the assertion
+}
+
+/*
+ * Verifies the assertion.  Unless opts.Strict is set, problems are
+ * logged and nil is returned.
+ */
+func VerifyAssertion(assertion string, opts VerifyOptions) error {
+	err := verifyAssertion(assertion, opts, time.Now())
+	if err == nil {
+		return nil
+	}
+	err = fmt.Errorf("%w: %s", ErrAssertionVerification, err.Error())
+	if !opts.Strict {
+		log.Warn(err.Error())
+		return nil
+	}
+	return err
+}
+
+func verifyAssertion(assertion string, opts VerifyOptions, now time.Time) error {
+	if len(opts.Certificates) == 0 && len(opts.Fingerprints) == 0 {
+		return fmt.Errorf("no IdP certificate or fingerprint configured")
+	}
+
+	doc := etree.NewDocument()
+	if err := doc.ReadFromString(assertion); err != nil {
+		return err
+	}
+	root := doc.Root()
+	if root == nil || root.Tag != "Response" {
+		return fmt.Errorf("not a SAML Response")
+	}
+
+	// More than one Assertion is a sign of a signature wrapping attack
+	if n := len(doc.FindElements("//Assertion")); n != 1 {
+		return fmt.Errorf("expected one Assertion, found %d", n)
+	}
+
+	// Either the Response or the Assertion may be signed
+	var signed *etree.Element
+	if sig := root.FindElement("./Signature"); sig != nil {
+		signed = root
+	} else if sig := root.FindElement("./Assertion/Signature"); sig != nil {
+		signed = root.FindElement("./Assertion")
+	} else {
+		return fmt.Errorf("SAML assertion is not signed")
+	}
+
+	certs, err := trustedCertificates(signed, opts)
+	if err != nil {
+		return err
+	}
+	ctx := dsig.NewDefaultValidationContext(&dsig.MemoryX509CertificateStore{
+		Roots: certs,
+	})
+	ctx.Clock = dsig.NewFakeClockAt(now)
+	validated, err := ctx.Validate(signed)
+	if err != nil {
+		return fmt.Errorf("invalid signature: %s", err.Error())
+	}
+
+	// Only trust the contents of the element covered by the signature
+	a := validated
+	if validated.Tag != "Assertion" {
+		a = validated.FindElement("./Assertion")
+		if a == nil {
+			return fmt.Errorf("signed Response does not contain an Assertion")
+		}
+	}
+	return checkConditions(a, opts.RoleARN, now)
+}
+
+// Returns the configured certificates plus the signing certificate if it matches a fingerprint
+func trustedCertificates(signed *etree.Element, opts VerifyOptions) ([]*x509.Certificate, error) {
+	certs := append([]*x509.Certificate{}, opts.Certificates...)
+	if len(opts.Fingerprints) == 0 {
+		return certs, nil
+	}
+
+	el := signed.FindElement("./Signature/KeyInfo/X509Data/X509Certificate")
+	if el == nil {
+		return certs, nil
+	}
+	data := strings.Join(strings.Fields(el.Text()), "")
+	der, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid X509Certificate: %s", err.Error())
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("invalid X509Certificate: %s", err.Error())
+	}
+
+	for _, fp := range opts.Fingerprints {
+		if fingerprintMatches(fp, der) {
+			return append(certs, cert), nil
+		}
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("signing certificate does not match the configured fingerprint")
+	}
+	return certs, nil
+}
+
+// Compares a hex fingerprint, with optional colons, to the certificate
+func fingerprintMatches(fingerprint string, der []byte) bool {
+	fp := strings.ToLower(strings.ReplaceAll(fingerprint, ":", ""))
+	switch len(fp) {
+	case sha1.Size * 2:
+		sum := sha1.Sum(der)
+		return fp == hex.EncodeToString(sum[:])
+	case sha256.Size * 2:
+		sum := sha256.Sum256(der)
+		return fp == hex.EncodeToString(sum[:])
+	}
+	return false
+}
+
+/*
+ * Checks the Audience and NotBefore/NotOnOrAfter window of the Assertion.
+ * If we know the role, the Recipient must be the AWS sign-in URL of its
+ * partition since STS won't accept the assertion otherwise.
+ */
+func checkConditions(assertion *etree.Element, role string, now time.Time) error {
+	conditions := assertion.FindElement("./Conditions")
+	if conditions == nil {
+		return fmt.Errorf("SAML assertion has no Conditions")
+	}
+
+	// the sign-in URLs are also valid audiences
+	audiences := []string{SAML_AUDIENCE}
+	for _, p := range Partitions {
+		audiences = append(audiences, p.SignInURL)
+	}
+	found := false
+	for _, el := range conditions.FindElements("./AudienceRestriction/Audience") {
+		for _, audience := range audiences {
+			if strings.TrimSpace(el.Text()) == audience {
+				found = true
+			}
+		}
+	}
+	if !found {
+		return fmt.Errorf("SAML assertion Audience is not %s", SAML_AUDIENCE)
+	}
+
+	if role != "" {
+		p, err := PartitionFromARN(role)
+		if err != nil {
+			return err
+		}
+		confirm := assertion.FindElement("./Subject/SubjectConfirmation/SubjectConfirmationData")
+		if confirm != nil {
+			recipient := confirm.SelectAttrValue("Recipient", "")
+			if recipient != "" && recipient != p.SignInURL {
+				return fmt.Errorf("SAML assertion Recipient is %s, not %s for the %s partition",
+					recipient, p.SignInURL, p.Name)
+			}
+		}
+	}
+
+	if nb := conditions.SelectAttrValue("NotBefore", ""); nb != "" {
+		t, err := time.Parse(time.RFC3339, nb)
+		if err != nil {
+			return fmt.Errorf("Unable to parse NotBefore %s: %s", nb, err.Error())
+		}
+		if now.Add(SAML_CLOCK_SKEW).Before(t) {
+			return fmt.Errorf("SAML assertion is not valid until %s", t.Local())
+		}
+	}
+	if noa := conditions.SelectAttrValue("NotOnOrAfter", ""); noa != "" {
+		t, err := time.Parse(time.RFC3339, noa)
+		if err != nil {
+			return fmt.Errorf("Unable to parse NotOnOrAfter %s: %s", noa, err.Error())
+		}
+		if !now.Add(-SAML_CLOCK_SKEW).Before(t) {
+			return fmt.Errorf("SAML assertion expired at %s", t.Local())
+		}
+	}
+	return nil
+}
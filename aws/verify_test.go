@@ -0,0 +1,274 @@
+package aws
+
+/*
+ * OneLogin AWS Role
+ * Copyright (c) 2020-2021 Aaron Turner  <aturner at synfin dot net>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/beevik/etree"
+	dsig "github.com/russellhaering/goxmldsig"
+)
+
+// When our test assertions are issued
+var testNow = time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+
+type testKeyStore struct {
+	key  *rsa.PrivateKey
+	cert []byte
+}
+
+func (ks *testKeyStore) GetKeyPair() (*rsa.PrivateKey, []byte, error) {
+	return ks.key, ks.cert, nil
+}
+
+func (ks *testKeyStore) certificate(t *testing.T) *x509.Certificate {
+	cert, err := x509.ParseCertificate(ks.cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+// Returns a self-signed IdP key and certificate
+func newTestKeyStore(t *testing.T) *testKeyStore {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "OneLogin Test IdP"},
+		NotBefore:    time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:     time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testKeyStore{key: key, cert: cert}
+}
+
+type testAssertionOptions struct {
+	audience     string
+	recipient    string
+	notBefore    time.Time
+	notOnOrAfter time.Time
+}
+
+func defaultAssertionOptions() testAssertionOptions {
+	return testAssertionOptions{
+		audience:     SAML_AUDIENCE,
+		recipient:    "https://signin.aws.amazon.com/saml",
+		notBefore:    testNow.Add(-time.Minute),
+		notOnOrAfter: testNow.Add(time.Hour),
+	}
+}
+
+// Returns an unsigned SAML Response
+func newTestResponse(opts testAssertionOptions) *etree.Document {
+	doc := etree.NewDocument()
+	resp := doc.CreateElement("samlp:Response")
+	resp.CreateAttr("xmlns:samlp", "urn:oasis:names:tc:SAML:2.0:protocol")
+	resp.CreateAttr("xmlns:saml", "urn:oasis:names:tc:SAML:2.0:assertion")
+	resp.CreateAttr("ID", "response-id")
+	resp.CreateElement("saml:Issuer").SetText("https://app.onelogin.com/saml/metadata/1")
+
+	// like OneLogin, so the Assertion can be signed on its own
+	a := resp.CreateElement("saml:Assertion")
+	a.CreateAttr("xmlns:saml", "urn:oasis:names:tc:SAML:2.0:assertion")
+	a.CreateAttr("ID", "assertion-id")
+	a.CreateElement("saml:Issuer").SetText("https://app.onelogin.com/saml/metadata/1")
+	subject := a.CreateElement("saml:Subject")
+	subject.CreateElement("saml:NameID").SetText("user@example.com")
+	confirm := subject.CreateElement("saml:SubjectConfirmation").CreateElement("saml:SubjectConfirmationData")
+	confirm.CreateAttr("Recipient", opts.recipient)
+
+	conditions := a.CreateElement("saml:Conditions")
+	conditions.CreateAttr("NotBefore", opts.notBefore.Format(time.RFC3339))
+	conditions.CreateAttr("NotOnOrAfter", opts.notOnOrAfter.Format(time.RFC3339))
+	conditions.CreateElement("saml:AudienceRestriction").CreateElement("saml:Audience").SetText(opts.audience)
+
+	attr := a.CreateElement("saml:AttributeStatement").CreateElement("saml:Attribute")
+	attr.CreateAttr("Name", SAML_ATTR_ROLE)
+	attr.CreateElement("saml:AttributeValue").SetText(testRoleARN + "," + testProviderARN)
+	return doc
+}
+
+// Signs the Response or its Assertion and returns the XML
+func signTestResponse(t *testing.T, ks *testKeyStore, doc *etree.Document, assertion bool) string {
+	ctx := dsig.NewDefaultSigningContext(ks)
+	// exclusive canonicalization like OneLogin, since the Assertion has a parent
+	ctx.Canonicalizer = dsig.MakeC14N10ExclusiveCanonicalizerWithPrefixList("")
+	el := doc.Root()
+	if assertion {
+		el = el.FindElement("./Assertion")
+	}
+	signed, err := ctx.SignEnveloped(el)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assertion {
+		root := doc.Root()
+		root.RemoveChild(el)
+		root.AddChild(signed)
+	} else {
+		doc.SetRoot(signed)
+	}
+	xml, err := doc.WriteToString()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return xml
+}
+
+func TestVerifyAssertion(t *testing.T) {
+	ks := newTestKeyStore(t)
+	cert := ks.certificate(t)
+	other := newTestKeyStore(t).certificate(t)
+	sha1sum := sha1.Sum(ks.cert)
+	sha256sum := sha256.Sum256(ks.cert)
+	otherSum := sha256.Sum256(other.Raw)
+
+	certOpts := VerifyOptions{Certificates: []*x509.Certificate{cert}}
+	signed := func(assertion bool) func() string {
+		return func() string {
+			return signTestResponse(t, ks, newTestResponse(defaultAssertionOptions()), assertion)
+		}
+	}
+	with := func(f func(*testAssertionOptions)) func() string {
+		return func() string {
+			opts := defaultAssertionOptions()
+			f(&opts)
+			return signTestResponse(t, ks, newTestResponse(opts), true)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		response func() string
+		opts     VerifyOptions
+		err      string // expected error substring, empty if valid
+	}{
+		{"signed Response", signed(false), certOpts, ""},
+		{"signed Assertion", signed(true), certOpts, ""},
+		{"SHA-1 fingerprint", signed(true), VerifyOptions{Fingerprints: []string{hex.EncodeToString(sha1sum[:])}}, ""},
+		{"SHA-256 fingerprint with colons", signed(false), VerifyOptions{
+			Fingerprints: []string{strings.ToUpper(strings.Join(splitPairs(hex.EncodeToString(sha256sum[:])), ":"))},
+		}, ""},
+		{"wrong certificate", signed(true), VerifyOptions{Certificates: []*x509.Certificate{other}}, "invalid signature"},
+		{"wrong fingerprint", signed(true), VerifyOptions{Fingerprints: []string{hex.EncodeToString(otherSum[:])}},
+			"does not match the configured fingerprint"},
+		{"no certificate configured", signed(true), VerifyOptions{}, "no IdP certificate"},
+		{"tampered Role", func() string {
+			xml := signed(true)()
+			return strings.Replace(xml, "role/Admin", "role/Evil", 1)
+		}, certOpts, "invalid signature"},
+		{"tampered Response", func() string {
+			xml := signed(false)()
+			return strings.Replace(xml, "user@example.com", "evil@example.com", 1)
+		}, certOpts, "invalid signature"},
+		{"missing signature", func() string {
+			xml, _ := newTestResponse(defaultAssertionOptions()).WriteToString()
+			return xml
+		}, certOpts, "not signed"},
+		{"two Assertions", func() string {
+			doc := etree.NewDocument()
+			if err := doc.ReadFromString(signed(true)()); err != nil {
+				t.Fatal(err)
+			}
+			evil := newTestResponse(defaultAssertionOptions()).Root().FindElement("./Assertion")
+			doc.Root().InsertChildAt(0, evil)
+			xml, _ := doc.WriteToString()
+			return xml
+		}, certOpts, "expected one Assertion"},
+		{"Assertion wrapped in the signed Assertion", func() string {
+			doc := newTestResponse(defaultAssertionOptions())
+			a := doc.Root().FindElement("./Assertion")
+			a.AddChild(a.Copy())
+			return signTestResponse(t, ks, doc, true)
+		}, certOpts, "expected one Assertion"},
+		{"not a Response", func() string { return `<Assertion/>` }, certOpts, "not a SAML Response"},
+		{"wrong Audience", with(func(o *testAssertionOptions) { o.audience = "urn:example:other" }), certOpts,
+			"Audience"},
+		{"sign-in URL Audience", with(func(o *testAssertionOptions) { o.audience = "https://signin.aws.amazon.com/saml" }),
+			certOpts, ""},
+		{"NotBefore at the clock skew", with(func(o *testAssertionOptions) { o.notBefore = testNow.Add(SAML_CLOCK_SKEW) }),
+			certOpts, ""},
+		{"NotBefore past the clock skew", with(func(o *testAssertionOptions) {
+			o.notBefore = testNow.Add(SAML_CLOCK_SKEW + time.Second)
+		}), certOpts, "not valid until"},
+		{"NotOnOrAfter at the clock skew", with(func(o *testAssertionOptions) {
+			o.notOnOrAfter = testNow.Add(-SAML_CLOCK_SKEW)
+		}), certOpts, "expired"},
+		{"NotOnOrAfter inside the clock skew", with(func(o *testAssertionOptions) {
+			o.notOnOrAfter = testNow.Add(-SAML_CLOCK_SKEW + time.Second)
+		}), certOpts, ""},
+		{"Recipient in the role's partition", signed(true), VerifyOptions{
+			Certificates: []*x509.Certificate{cert},
+			RoleARN:      testRoleARN,
+		}, ""},
+		{"Recipient in another partition", signed(true), VerifyOptions{
+			Certificates: []*x509.Certificate{cert},
+			RoleARN:      "arn:aws-us-gov:iam::123456789012:role/Admin",
+		}, "Recipient"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifyAssertion(tt.response(), tt.opts, testNow)
+			if tt.err == "" {
+				if err != nil {
+					t.Errorf("verifyAssertion(): %s", err)
+				}
+			} else if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("verifyAssertion() error = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestVerifyAssertionStrict(t *testing.T) {
+	xml, _ := newTestResponse(defaultAssertionOptions()).WriteToString()
+	cert := newTestKeyStore(t).certificate(t)
+	if err := VerifyAssertion(xml, VerifyOptions{Certificates: []*x509.Certificate{cert}}); err != nil {
+		t.Errorf("VerifyAssertion() without strict: %s", err)
+	}
+	err := VerifyAssertion(xml, VerifyOptions{Certificates: []*x509.Certificate{cert}, Strict: true})
+	if err == nil || !strings.Contains(err.Error(), ErrAssertionVerification.Error()) {
+		t.Errorf("VerifyAssertion() with strict error = %v", err)
+	}
+}
+
+// Splits a hex string into pairs of digits
+func splitPairs(s string) []string {
+	pairs := []string{}
+	for i := 0; i+1 < len(s); i += 2 {
+		pairs = append(pairs, s[i:i+2])
+	}
+	return pairs
+}
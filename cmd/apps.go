@@ -0,0 +1,106 @@
+package main
+
+/*
+ * OneLogin AWS Role
+ * Copyright (c) 2020-2021 Aaron Turner  <aturner at synfin dot net>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"fmt"
+	"reflect"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/synfinatic/onelogin-aws-role/utils"
+)
+
+type AppsCmd struct {
+	Discover AppsDiscoverCmd `kong:"cmd,help='List the AWS apps assigned to you in OneLogin'"`
+}
+
+type AppsDiscoverCmd struct {
+	Identity string `kong:"optional,short='i',name='identity',default='default',help='OneLogin identity to use'"`
+	Add      bool   `kong:"optional,name='add',help='Add discovered apps to the config file'"`
+}
+
+type AppsDiscoverRow struct {
+	Id   uint32 `header:"AppID"`
+	Name string `header:"App Name"`
+	Icon string `header:"Icon"`
+}
+
+func (ac *AppsDiscoverCmd) Run(ctx *RunContext) error {
+	cli := *ctx.Cli
+	identity, err := ctx.Config.GetIdentity(cli.Apps.Discover.Identity)
+	if err != nil {
+		return err
+	}
+	kr, err := OpenKeyring(nil)
+	if err != nil {
+		return fmt.Errorf("Unable to open KeyChain for OneLogin Oauth: %s", err)
+	}
+	o, err := ConnectOneLogin(ctx, kr, identity)
+	if err != nil {
+		return err
+	}
+
+	apps, err := o.GetUserAWSApps(identity.Username)
+	if err != nil {
+		return err
+	}
+	if len(apps) == 0 {
+		fmt.Printf("No AWS apps are assigned to %s\n", identity.Username)
+		return nil
+	}
+
+	ts := []utils.TableStruct{}
+	for _, app := range apps {
+		ts = append(ts, AppsDiscoverRow{Id: app.Id, Name: app.Name, Icon: app.IconLabel()})
+	}
+	utils.GenerateTable(ts, []string{"Id", "Name", "Icon"})
+
+	if !cli.Apps.Discover.Add {
+		return nil
+	}
+
+	newApps := map[uint32]AppConfig{}
+	for _, app := range apps {
+		if ctx.Config.Apps != nil {
+			if _, ok := (*ctx.Config.Apps)[app.Id]; ok {
+				log.Infof("AppID %d is already in the config file", app.Id)
+				continue
+			}
+		}
+		appConfig := AppConfig{
+			Name:  app.Name,
+			Roles: &[]RoleConfig{},
+		}
+		if identity.Name != DEFAULT_IDENTITY {
+			appConfig.Identity = identity.Name
+		}
+		newApps[app.Id] = appConfig
+	}
+	if len(newApps) == 0 {
+		return nil
+	}
+	log.Infof("Adding %d apps to %s", len(newApps), GetPath(cli.ConfigFile))
+	return AddAppsToConfigFile(cli.ConfigFile, newApps)
+}
+
+// Necessary for util.GenerateTable
+func (r AppsDiscoverRow) GetHeader(fieldName string) (string, error) {
+	v := reflect.ValueOf(r)
+	return utils.GetHeaderTag(v, fieldName)
+}
@@ -0,0 +1,215 @@
+package main
+
+/*
+ * OneLogin AWS Role
+ * Copyright (c) 2020-2021 Aaron Turner  <aturner at synfin dot net>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/synfinatic/onelogin-aws-role/aws"
+	"github.com/synfinatic/onelogin-aws-role/utils"
+)
+
+type AssertionCmd struct {
+	Inspect AssertionInspectCmd `kong:"cmd,help='Print the contents of a SAML assertion'"`
+}
+
+type AssertionInspectCmd struct {
+	App   string `kong:"optional,short='a',name='app',help='Inspect the cached assertion for this OneLogin AppID or alias'"`
+	File  string `kong:"optional,short='f',name='file',help='Read the assertion (XML or base64) from a file'"`
+	Stdin string `kong:"arg,optional,name='-',help='Use - to read the assertion from stdin'"`
+	Json  bool   `kong:"optional,name='json',help='Print as JSON'"`
+}
+
+type AssertionAttributeRow struct {
+	Name  string `header:"Attribute"`
+	Value string `header:"Value"`
+}
+
+type AssertionRoleRow struct {
+	RoleARN      string `header:"Role ARN"`
+	PrincipalARN string `header:"Principal ARN"`
+}
+
+func (ac *AssertionInspectCmd) Run(ctx *RunContext) error {
+	assertion, err := ac.readAssertion(ctx)
+	if err != nil {
+		return err
+	}
+	info, err := aws.InspectAssertion(assertion)
+	if err != nil {
+		return fmt.Errorf("Unable to parse SAML assertion: %s", err.Error())
+	}
+
+	if ac.Json {
+		out, err := json.MarshalIndent(info, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(out))
+		return nil
+	}
+
+	fmt.Printf("Issuer:            %s\n", info.Issuer)
+	fmt.Printf("Subject:           %s\n", info.Subject)
+	if info.SubjectFormat != "" {
+		fmt.Printf("Subject Format:    %s\n", info.SubjectFormat)
+	}
+	if info.Recipient != "" {
+		fmt.Printf("Recipient:         %s\n", info.Recipient)
+	}
+	fmt.Printf("Audience:          %s\n", strings.Join(info.Audiences, ", "))
+	fmt.Printf("Response Signed:   %v\n", info.ResponseSigned)
+	fmt.Printf("Assertion Signed:  %v\n", info.AssertionSigned)
+	now := time.Now()
+	printSAMLTime("Issued At:", info.IssueInstant, now)
+	printSAMLTime("Not Before:", info.NotBefore, now)
+	printSAMLTime("Not On Or After:", info.NotOnOrAfter, now)
+	printSAMLTime("Session Ends:", info.SessionNotAfter, now)
+	if info.RoleSessionName != "" {
+		fmt.Printf("Role Session Name: %s\n", info.RoleSessionName)
+	}
+	if info.SessionDuration > 0 {
+		fmt.Printf("Session Duration:  %s\n", time.Duration(info.SessionDuration)*time.Second)
+	}
+
+	fmt.Printf("\n")
+	ts := []utils.TableStruct{}
+	for _, attr := range info.Attributes {
+		for _, value := range attr.Values {
+			ts = append(ts, AssertionAttributeRow{Name: attr.Name, Value: value})
+		}
+		if len(attr.Values) == 0 {
+			ts = append(ts, AssertionAttributeRow{Name: attr.Name})
+		}
+	}
+	utils.GenerateTable(ts, []string{"Name", "Value"})
+
+	fmt.Printf("\n")
+	if len(info.Roles) == 0 {
+		fmt.Printf("No valid AWS roles in SAML assertion\n")
+	} else {
+		ts = []utils.TableStruct{}
+		for _, role := range info.Roles {
+			ts = append(ts, AssertionRoleRow{RoleARN: role.RoleARN, PrincipalARN: role.PrincipalARN})
+		}
+		utils.GenerateTable(ts, []string{"RoleARN", "PrincipalARN"})
+	}
+
+	if len(info.RoleErrors) > 0 || len(info.Errors) > 0 {
+		fmt.Printf("\n")
+	}
+	for _, roleErr := range info.RoleErrors {
+		fmt.Printf("Error: %s\n", roleErr)
+	}
+	for _, e := range info.Errors {
+		fmt.Printf("Error: %s\n", e)
+	}
+	return nil
+}
+
+// Returns the assertion from the cache, a file or stdin
+func (ac *AssertionInspectCmd) readAssertion(ctx *RunContext) (string, error) {
+	var data []byte
+	var err error
+
+	sources := 0
+	for _, s := range []string{ac.App, ac.File, ac.Stdin} {
+		if s != "" {
+			sources++
+		}
+	}
+	if sources != 1 {
+		return "", fmt.Errorf("Please specify exactly one of --app, --file or -")
+	}
+
+	switch {
+	case ac.App != "":
+		return cachedAssertion(ctx, ac.App)
+	case ac.File != "":
+		data, err = ioutil.ReadFile(GetPath(ac.File))
+	case ac.Stdin == "-":
+		data, err = ioutil.ReadAll(os.Stdin)
+	default:
+		return "", fmt.Errorf("Invalid argument '%s': use --file to read a file", ac.Stdin)
+	}
+	if err != nil {
+		return "", err
+	}
+	return aws.DecodeAssertion(data)
+}
+
+/*
+ * Returns the cached assertion for the app, even if it has expired since
+ * that is often exactly what we are trying to debug.
+ */
+func cachedAssertion(ctx *RunContext, app string) (string, error) {
+	appid, err := ctx.Config.GetAppId(app)
+	if err != nil {
+		return "", err
+	}
+	identity, err := ctx.Config.GetIdentity(ctx.Config.GetAppIdentity(appid))
+	if err != nil {
+		return "", err
+	}
+	kr, err := OpenKeyring(nil)
+	if err != nil {
+		return "", fmt.Errorf("Unable to open KeyChain: %s", err)
+	}
+	cache, err := OpenCache(ctx, kr, identity)
+	if err != nil {
+		return "", err
+	}
+	assertion, ok := cache.Assertion[fmt.Sprintf("%d", appid)]
+	if !ok {
+		return "", fmt.Errorf("No cached SAML assertion for app %d.  Use the exec command to login first", appid)
+	}
+	return assertion.Assertion, nil
+}
+
+// Prints a SAML time along with how long until/since it
+func printSAMLTime(label string, t *time.Time, now time.Time) {
+	if t == nil {
+		return
+	}
+	var rel string
+	if d := t.Sub(now).Round(time.Second); d >= 0 {
+		rel = fmt.Sprintf("in %s", d)
+	} else {
+		rel = fmt.Sprintf("%s ago", -d)
+	}
+	fmt.Printf("%-18s %s (%s)\n", label, t.Local().Format(time.RFC3339), rel)
+}
+
+// Necessary for util.GenerateTable
+func (r AssertionAttributeRow) GetHeader(fieldName string) (string, error) {
+	v := reflect.ValueOf(r)
+	return utils.GetHeaderTag(v, fieldName)
+}
+
+// Necessary for util.GenerateTable
+func (r AssertionRoleRow) GetHeader(fieldName string) (string, error) {
+	v := reflect.ValueOf(r)
+	return utils.GetHeaderTag(v, fieldName)
+}
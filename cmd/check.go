@@ -20,21 +20,34 @@ package main
 
 import (
 	"fmt"
-
-	"github.com/synfinatic/onelogin-aws-role/onelogin"
+	"time"
 )
 
 type CheckCmd struct {
-	// No sub options
+	Identity string `kong:"optional,short='i',name='identity',default='default',help='OneLogin identity to check'"`
 }
 
 func (cc *CheckCmd) Run(ctx *RunContext) error {
-	c := onelogin.LoadOneLoginCache("")
-	expired := c.AccessToken.IsExpired()
-	if expired {
+	identity, err := ctx.Config.GetIdentity(cc.Identity)
+	if err != nil {
+		return err
+	}
+	kr, err := OpenKeyring(nil)
+	if err != nil {
+		return fmt.Errorf("Unable to open KeyChain: %s", err)
+	}
+	c, err := OpenCache(ctx, kr, identity)
+	if err != nil {
+		return err
+	}
+	expires, err := c.AccessToken.ExpiresAt()
+	if err != nil {
+		return err
+	}
+	if time.Now().After(expires) {
 		fmt.Println("OneLogin OAuth2 token has expired.")
 	} else {
-		fmt.Printf("OneLogin OAuth2 token will expire at: %s\n", c.AccessToken.ExpiresAt())
+		fmt.Printf("OneLogin OAuth2 token will expire at: %s\n", expires.Local().String())
 	}
 
 	return nil
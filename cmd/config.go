@@ -26,58 +26,176 @@ package main
  */
 
 import (
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
 	"fmt"
+	"io/ioutil"
+	"net/http"
 	"os"
+	"path/filepath"
 	"reflect"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	yaml "github.com/goccy/go-yaml"
 	log "github.com/sirupsen/logrus"
+	"github.com/synfinatic/onelogin-aws-role/aws"
+	"github.com/synfinatic/onelogin-aws-role/onelogin"
 	"github.com/synfinatic/onelogin-aws-role/utils"
 )
 
 // ConfigFile structure
 type ConfigFile struct {
-	Region    string                `yaml:"region"`                                    // OneLogin Region
-	Username  string                `yaml:"username"`                                  // or email address
-	Subdomain string                `yaml:"subdomain"`                                 // XXXX.onelogin.com
-	Mfa       int32                 `yaml:"mfa"`                                       // MFA device_id to use by default
-	Accounts  *map[uint64]string    `yaml:"aws_accounts,omitempty" header:"AccountID"` // AWS AccountID is the key
-	Apps      *map[uint32]AppConfig `yaml:"apps" header:"AppID"`                       // OneLogin AppID is the key
-	Fields    *[]string             `yaml:"fields,omitempty" header:"Fields"`          // List of fields to report with `list` command
+	Region           string                     `yaml:"region"`                                    // OneLogin Region
+	Username         string                     `yaml:"username"`                                  // or email address
+	Subdomain        string                     `yaml:"subdomain"`                                 // XXXX.onelogin.com
+	Mfa              *MfaConfig                 `yaml:"mfa,omitempty"`                             // MFA device selection
+	RememberPassword bool                       `yaml:"remember_password,omitempty"`               // Store the OneLogin password in the KeyChain
+	PasswordCommand  string                     `yaml:"password_command,omitempty"`                // Command which prints the OneLogin password to stdout
+	Identities       *map[string]IdentityConfig `yaml:"identities,omitempty"`                      // Additional OneLogin accounts
+	Accounts         *map[uint64]string         `yaml:"aws_accounts,omitempty" header:"AccountID"` // AWS AccountID is the key
+	Apps             *map[uint32]AppConfig      `yaml:"apps" header:"AppID"`                       // OneLogin AppID is the key
+	Fields           *[]string                  `yaml:"fields,omitempty" header:"Fields"`          // List of fields to report with `list` command
+	Push             *PushConfig                `yaml:"push,omitempty"`                            // OneLogin Protect push settings
+	Network          *NetworkConfig             `yaml:"network,omitempty"`                         // HTTP proxy & TLS settings
+	Cache            string                     `yaml:"cache,omitempty"`                           // Where to store SAML assertions & the OneLogin token
+	Saml             *SAMLConfig                `yaml:"saml,omitempty"`                            // SAML assertion verification
+	StsEndpoint      string                     `yaml:"sts_endpoint,omitempty"`                    // URL of the AWS STS endpoint
+	UseRegionalSts   bool                       `yaml:"use_regional_sts,omitempty"`                // Use the regional STS endpoints
+	UseFipsSts       bool                       `yaml:"use_fips_sts,omitempty"`                    // Use the FIPS STS endpoints
+}
+
+// Valid values for `cache`
+const (
+	CACHE_FILE           = "file"
+	CACHE_ENCRYPTED_FILE = "encrypted-file"
+	CACHE_KEYRING        = "keyring"
+)
+
+// The name of the identity defined by the top level of the config file
+const DEFAULT_IDENTITY = "default"
+
+/*
+ * A OneLogin user account.  Each identity has its own Oauth credentials
+ * in the KeyChain and its own cache file.
+ */
+type IdentityConfig struct {
+	Name             string `yaml:"-"`
+	Region           string `yaml:"region"`                      // OneLogin Region
+	Username         string `yaml:"username"`                    // or email address
+	Subdomain        string `yaml:"subdomain"`                   // XXXX.onelogin.com
+	RememberPassword bool   `yaml:"remember_password,omitempty"` // Store the OneLogin password in the KeyChain
+	PasswordCommand  string `yaml:"password_command,omitempty"`  // Command which prints the OneLogin password to stdout
+}
+
+// OneLogin Protect push config
+type PushConfig struct {
+	Timeout  int64   `yaml:"timeout"`  // seconds to wait for the user to approve
+	Interval float64 `yaml:"interval"` // seconds between polls
+	Backoff  float64 `yaml:"backoff"`  // multiplier applied to interval after each poll
+}
+
+// HTTP proxy & TLS settings for talking to OneLogin and AWS
+type NetworkConfig struct {
+	Proxy         string   `yaml:"proxy,omitempty"`           // http(s)://[user:pass@]host:port
+	NoProxy       []string `yaml:"no_proxy,omitempty"`        // hosts/domains/CIDRs which bypass the proxy
+	CABundle      string   `yaml:"ca_bundle,omitempty"`       // PEM file of additional trusted CA certs
+	ClientCert    string   `yaml:"client_cert,omitempty"`     // PEM client certificate for mTLS
+	ClientKey     string   `yaml:"client_key,omitempty"`      // PEM client key for mTLS
+	MinTLSVersion string   `yaml:"min_tls_version,omitempty"` // 1.0, 1.1, 1.2 or 1.3
+}
+
+// How to verify the SAML assertions from OneLogin
+type SAMLConfig struct {
+	IdPCert        string `yaml:"idp_cert,omitempty"`        // PEM file with the OneLogin IdP certificate
+	IdPFingerprint string `yaml:"idp_fingerprint,omitempty"` // SHA-1 or SHA-256 fingerprint of the IdP certificate
+	Strict         bool   `yaml:"strict,omitempty"`          // refuse assertions which fail verification
+}
+
+// MFA config.  For backwards compatibility, `mfa: <device_id>` is also accepted
+type MfaConfig struct {
+	DeviceId    int32    `yaml:"device_id,omitempty"`   // MFA device_id to use by default
+	Preferences []string `yaml:"preferences,omitempty"` // Ordered list of MFA device types to use
 }
 
 // App config
 type AppConfig struct {
-	Name  string        `yaml:"name" header:"App Name"`
-	Alias string        `yaml:"alias" header:"App Alias"`
-	Roles *[]RoleConfig `yaml:"roles"`
+	Name        string        `yaml:"name" header:"App Name"`
+	Alias       string        `yaml:"alias" header:"App Alias"`
+	Identity    string        `yaml:"identity,omitempty" header:"Identity"` // defaults to the top level OneLogin account
+	Mfa         *MfaConfig    `yaml:"mfa,omitempty"`                        // overrides the global MFA config
+	Saml        *SAMLConfig   `yaml:"saml,omitempty"`                       // overrides the global SAML config
+	StsEndpoint string        `yaml:"sts_endpoint,omitempty"`               // overrides the global STS endpoint
+	Roles       *[]RoleConfig `yaml:"roles"`
 }
 
 // Role config
 type RoleConfig struct {
-	Arn     string `yaml:"arn" header:"ARN"`
-	Profile string `yaml:"profile" header:"AWS Profile"`
-	Region  string `yaml:"region" header:"Default Region"` // Default AWS Region
+	Arn           string   `yaml:"arn" header:"ARN"`
+	Profile       string   `yaml:"profile" header:"AWS Profile"`
+	Region        string   `yaml:"region" header:"Default Region"`                    // Default AWS Region
+	SourceProfile string   `yaml:"source_profile,omitempty" header:"Source Profile"`  // AssumeRole from this profile instead of using SAML
+	ExternalId    string   `yaml:"external_id,omitempty" header:"External ID"`        // ExternalId for AssumeRole
+	SessionName   string   `yaml:"session_name,omitempty" header:"Role Session Name"` // RoleSessionName for AssumeRole
+	Policy        string   `yaml:"policy,omitempty" header:"Session Policy"`          // inline JSON session policy or path to one
+	PolicyArns    []string `yaml:"policy_arns,omitempty"`                             // managed session policies
+	StsEndpoint   string   `yaml:"sts_endpoint,omitempty"`                            // overrides the app's STS endpoint
 }
 
 // Flattened Config for displaying report
 type FlatConfig struct {
-	AccountId   uint64 `header:"AWS AccountID"`
-	AccountName string `header:"Account Name"`
-	AppId       uint32 `header:"OneLogin AppID"`
-	AppName     string `header:"App Name"`
-	AppAlias    string `header:"App Alias"`
-	Arn         string `header:"Role ARN"`
-	Profile     string `header:"$AWS_PROFILE"`
-	Region      string `header:"Default Region"`
-	Expires     string `header:"Expires"`
-}
-
-// Returns the config file path.
+	AccountId     uint64 `header:"AWS AccountID"`
+	AccountName   string `header:"Account Name"`
+	AppId         uint32 `header:"OneLogin AppID"`
+	AppName       string `header:"App Name"`
+	AppAlias      string `header:"App Alias"`
+	Identity      string `header:"Identity"`
+	Partition     string `header:"Partition"`
+	Arn           string `header:"Role ARN"`
+	Profile       string `header:"$AWS_PROFILE"`
+	Region        string `header:"Default Region"`
+	Expires       string `header:"Expires"`
+	SessionName   string `header:"Role Session Name"`
+	SourceProfile string `header:"Source Profile"`
+}
+
+/*
+ * Returns the http.Client to use for OneLogin and AWS API calls.  Returns nil
+ * if there is no network config so the library defaults are used.
+ */
+func (cf *ConfigFile) GetHTTPClient() (*http.Client, error) {
+	if cf.Network == nil {
+		return nil, nil
+	}
+	n := cf.Network
+	opts := utils.HTTPClientOptions{
+		Proxy:         n.Proxy,
+		NoProxy:       n.NoProxy,
+		MinTLSVersion: n.MinTLSVersion,
+	}
+	if n.CABundle != "" {
+		opts.CABundle = GetPath(n.CABundle)
+	}
+	if n.ClientCert != "" {
+		opts.ClientCert = GetPath(n.ClientCert)
+	}
+	if n.ClientKey != "" {
+		opts.ClientKey = GetPath(n.ClientKey)
+	}
+	client, err := utils.NewHTTPClient(opts)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid network config: %s", err.Error())
+	}
+	return client, nil
+}
+
+// Returns the path with ~ expanded to the user's home directory
 func GetPath(path string) string {
-	return strings.Replace(path, "~", os.Getenv("HOME"), 1)
+	return utils.ExpandPath(path)
 }
 
 // Loads our config file at the given path
@@ -106,29 +224,153 @@ func LoadConfigFile(path string) (*ConfigFile, error) {
 	if err != nil {
 		return nil, fmt.Errorf("Error parsing %s: %s", fullpath, err.Error())
 	}
+	err = c.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("Error in %s: %s", fullpath, err.Error())
+	}
 
 	return &c, nil
 }
 
+// Identity names are used in file names, so they must not contain paths
+var validIdentityName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// Checks the parts of the config which can't be checked while parsing
+func (c *ConfigFile) Validate() error {
+	if c.Identities == nil {
+		return nil
+	}
+	for name := range *c.Identities {
+		if !validIdentityName.MatchString(name) {
+			return fmt.Errorf("Invalid identity name '%s': only letters, numbers, '_' and '-' are allowed", name)
+		}
+	}
+	return nil
+}
+
+// The top level `apps:` key, its value and any trailing comment
+var appsKey = regexp.MustCompile(`^apps\s*:\s*([^#]*?)\s*(#.*)?$`)
+
+/*
+ * Adds the apps to the `apps` section of the config file at the given path.
+ * We edit the file as text rather than re-generating it so that the user's
+ * comments and formatting are preserved.
+ */
+func AddAppsToConfigFile(path string, apps map[uint32]AppConfig) error {
+	fullpath := GetPath(path)
+	info, err := os.Stat(fullpath)
+	if err != nil {
+		return fmt.Errorf("Unable to read %s: %s", fullpath, err.Error())
+	}
+	buf, err := ioutil.ReadFile(fullpath)
+	if err != nil {
+		return fmt.Errorf("Unable to read %s: %s", fullpath, err.Error())
+	}
+	lines := strings.Split(string(buf), "\n")
+
+	// find our apps section and how it is indented
+	appsLine := -1
+	indent := "    "
+	for i, line := range lines {
+		if appsLine < 0 {
+			m := appsKey.FindStringSubmatch(line)
+			if m == nil {
+				continue
+			}
+			switch m[1] {
+			case "":
+				appsLine = i
+			case "{}", "null", "~":
+				lines[i] = strings.TrimRight("apps: "+m[2], " ")
+				appsLine = i
+			default:
+				return fmt.Errorf("Unable to add apps to %s: please put each app on its own line", fullpath)
+			}
+			continue
+		}
+		trimmed := strings.TrimLeft(line, " \t")
+		if trimmed != "" && !strings.HasPrefix(trimmed, "#") {
+			if len(trimmed) < len(line) {
+				indent = line[:len(line)-len(trimmed)]
+			}
+			break
+		}
+	}
+
+	ids := []int{}
+	for id := range apps {
+		ids = append(ids, int(id))
+	}
+	sort.Ints(ids)
+	snippet := []string{}
+	for _, id := range ids {
+		app := apps[uint32(id)]
+		snippet = append(snippet, fmt.Sprintf("%s%d:", indent, id))
+		snippet = append(snippet, fmt.Sprintf("%s%sname: %s", indent, indent, strconv.Quote(app.Name)))
+		if app.Identity != "" {
+			snippet = append(snippet, fmt.Sprintf("%s%sidentity: %s", indent, indent, app.Identity))
+		}
+		snippet = append(snippet, fmt.Sprintf("%s%sroles: []", indent, indent))
+	}
+
+	if appsLine < 0 {
+		if len(lines) > 0 && lines[len(lines)-1] == "" {
+			lines = lines[:len(lines)-1]
+		}
+		lines = append(lines, "apps:")
+		lines = append(lines, snippet...)
+		lines = append(lines, "")
+	} else {
+		newLines := append([]string{}, lines[:appsLine+1]...)
+		newLines = append(newLines, snippet...)
+		lines = append(newLines, lines[appsLine+1:]...)
+	}
+
+	// make sure we didn't break the user's config
+	out := []byte(strings.Join(lines, "\n"))
+	if err = yaml.Unmarshal(out, &ConfigFile{}); err != nil {
+		return fmt.Errorf("Unable to add apps to %s: %s", fullpath, err.Error())
+	}
+
+	// replace the file a symlinked config points to, not the symlink
+	target := fullpath
+	if real, err := filepath.EvalSymlinks(fullpath); err == nil {
+		target = real
+	}
+	// keep the user's config intact and its permissions unchanged if we crash
+	err = utils.WriteFileAtomic(target, out, info.Mode().Perm())
+	if err != nil {
+		return fmt.Errorf("Unable to write %s: %s", fullpath, err.Error())
+	}
+	return nil
+}
+
 func (c *ConfigFile) roleToFlatConfig(appid uint32, app AppConfig, role RoleConfig) *FlatConfig {
 	accountid, err := GetAccountFromARN(role.Arn)
 	if err != nil {
 		log.WithError(err).Warnf("Unable to get AWS Account ID for role '%s'", role.Arn)
 	}
+	partition := "<Unknown>"
+	if p, err := aws.PartitionFromARN(role.Arn); err == nil {
+		partition = p.Name
+	}
 	accountname := "<Unknown>"
 	if c.Accounts != nil {
 		a := *c.Accounts
 		accountname, _ = a[accountid]
 	}
 	fc := FlatConfig{
-		AccountId:   accountid,
-		AccountName: accountname,
-		AppId:       appid,
-		AppName:     app.Name,
-		AppAlias:    app.Alias,
-		Arn:         role.Arn,
-		Profile:     role.Profile,
-		Region:      role.Region,
+		AccountId:     accountid,
+		AccountName:   accountname,
+		AppId:         appid,
+		AppName:       app.Name,
+		AppAlias:      app.Alias,
+		Identity:      c.GetAppIdentity(appid),
+		Partition:     partition,
+		Arn:           role.Arn,
+		Profile:       role.Profile,
+		Region:        role.Region,
+		SourceProfile: role.SourceProfile,
 	}
 	return &fc
 }
@@ -196,7 +438,7 @@ func (c *ConfigFile) GetApps() *map[uint32]string {
 
 // Return the AWS Role ARN based on the profile (or itself if an ARN)
 func (c *ConfigFile) GetRoleArn(profile_or_arn string) (string, error) {
-	if strings.HasPrefix(profile_or_arn, "arn:aws:iam:") {
+	if aws.IsIAMArn(profile_or_arn) {
 		// looks like an ARN
 		return profile_or_arn, nil
 	}
@@ -211,6 +453,51 @@ func (c *ConfigFile) GetRoleArn(profile_or_arn string) (string, error) {
 	return "", fmt.Errorf("Unable to locate Role: %s", profile_or_arn)
 }
 
+// Returns the config for the role with the given profile or ARN
+func (c *ConfigFile) GetRoleConfig(profile_or_arn string) (*RoleConfig, error) {
+	for _, app := range *c.Apps {
+		for _, role := range *app.Roles {
+			if role.Profile == profile_or_arn || role.Arn == profile_or_arn {
+				return &role, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("Unable to locate Role: %s", profile_or_arn)
+}
+
+// Returns the session policy for the role, if any
+func (c *ConfigFile) GetSessionPolicy(profile_or_arn string) (aws.SessionPolicy, error) {
+	policy := aws.SessionPolicy{}
+	role, err := c.GetRoleConfig(profile_or_arn)
+	if err != nil {
+		return policy, nil // ARNs which are not in our config have no policy
+	}
+	if role.Policy != "" {
+		policy.Policy, err = ReadPolicy(role.Policy)
+		if err != nil {
+			return policy, err
+		}
+	}
+	policy.PolicyArns = role.PolicyArns
+	return policy, nil
+}
+
+// Returns the JSON policy, which may be inline or the path to a file
+func ReadPolicy(policy string) (string, error) {
+	policy = strings.TrimSpace(policy)
+	if !strings.HasPrefix(policy, "{") {
+		data, err := ioutil.ReadFile(GetPath(policy))
+		if err != nil {
+			return "", fmt.Errorf("Unable to read session policy: %s", err.Error())
+		}
+		policy = strings.TrimSpace(string(data))
+	}
+	if !json.Valid([]byte(policy)) {
+		return "", fmt.Errorf("Session policy is not valid JSON")
+	}
+	return policy, nil
+}
+
 // Returns the default region for a given role or error if not set
 func (c *ConfigFile) GetRoleRegion(profile string) (string, error) {
 	for _, app := range *c.Apps {
@@ -239,6 +526,30 @@ func (c *ConfigFile) GetApp(alias_or_id string) (*AppConfig, error) {
 	return nil, fmt.Errorf("Unable to locate AppId: %s", alias_or_id)
 }
 
+/*
+ * Find the AppID using the Id or alias.  If alias_or_id is empty, returns
+ * the lowest AppID in the config file.
+ */
+func (c *ConfigFile) GetAppId(alias_or_id string) (uint32, error) {
+	if c.Apps == nil || len(*c.Apps) == 0 {
+		return 0, fmt.Errorf("No apps are defined in the config file")
+	}
+	var lowest uint32 = 0
+	for id, val := range *c.Apps {
+		if alias_or_id == "" {
+			if lowest == 0 || id < lowest {
+				lowest = id
+			}
+		} else if val.Alias == alias_or_id || fmt.Sprintf("%d", id) == alias_or_id {
+			return id, nil
+		}
+	}
+	if alias_or_id == "" {
+		return lowest, nil
+	}
+	return 0, fmt.Errorf("Unable to locate AppId: %s", alias_or_id)
+}
+
 /*
  * Find the AppID for a Role Profile
  */
@@ -259,6 +570,170 @@ func (c *ConfigFile) GetAppIdForRole(alias string) (uint32, error) {
 	return 0, fmt.Errorf("Unable to find Role with alias or name: %s", alias)
 }
 
+// Support the legacy `mfa: <device_id>` format
+func (m *MfaConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var device_id int32
+	if err := unmarshal(&device_id); err == nil {
+		m.DeviceId = device_id
+		return nil
+	}
+
+	type plain MfaConfig // avoid recursion
+	p := plain{}
+	if err := unmarshal(&p); err != nil {
+		return err
+	}
+	*m = MfaConfig(p)
+	return nil
+}
+
+/*
+ * Returns the named OneLogin identity.  DEFAULT_IDENTITY (or an empty name)
+ * is the account defined at the top level of the config file.
+ */
+func (c *ConfigFile) GetIdentity(name string) (*IdentityConfig, error) {
+	if name == "" {
+		name = DEFAULT_IDENTITY
+	}
+	if c.Identities != nil {
+		if identity, ok := (*c.Identities)[name]; ok {
+			identity.Name = name
+			return &identity, nil
+		}
+	}
+	if name == DEFAULT_IDENTITY {
+		identity := IdentityConfig{
+			Name:             DEFAULT_IDENTITY,
+			Region:           c.Region,
+			Username:         c.Username,
+			Subdomain:        c.Subdomain,
+			RememberPassword: c.RememberPassword,
+			PasswordCommand:  c.PasswordCommand,
+		}
+		return &identity, nil
+	}
+	return nil, fmt.Errorf("Unable to locate identity: %s", name)
+}
+
+// Returns the name of the identity used to login to the given AppID
+func (c *ConfigFile) GetAppIdentity(appid uint32) string {
+	if c.Apps != nil {
+		if app, ok := (*c.Apps)[appid]; ok && app.Identity != "" {
+			return app.Identity
+		}
+	}
+	return DEFAULT_IDENTITY
+}
+
+/*
+ * Returns the STS endpoint for the role.  The role's sts_endpoint overrides
+ * the app's which overrides the global one.
+ */
+func (c *ConfigFile) GetSTSEndpoint(profile_or_arn string) aws.STSEndpoint {
+	endpoint := aws.STSEndpoint{
+		Endpoint:    c.StsEndpoint,
+		UseRegional: c.UseRegionalSts,
+		UseFIPS:     c.UseFipsSts,
+	}
+	if appid, err := c.GetAppIdForRole(profile_or_arn); err == nil {
+		if app := (*c.Apps)[appid]; app.StsEndpoint != "" {
+			endpoint.Endpoint = app.StsEndpoint
+		}
+	}
+	if role, err := c.GetRoleConfig(profile_or_arn); err == nil && role.StsEndpoint != "" {
+		endpoint.Endpoint = role.StsEndpoint
+	}
+	return endpoint
+}
+
+/*
+ * Returns the options to verify SAML assertions for the given AppID or nil
+ * if verification is not configured.  The app's SAML config replaces the global one.
+ */
+func (c *ConfigFile) GetVerifyOptions(appid uint32) (*aws.VerifyOptions, error) {
+	saml := c.Saml
+	if c.Apps != nil {
+		if app, ok := (*c.Apps)[appid]; ok && app.Saml != nil {
+			saml = app.Saml
+		}
+	}
+	if saml == nil || (saml.IdPCert == "" && saml.IdPFingerprint == "" && !saml.Strict) {
+		return nil, nil
+	}
+
+	opts := aws.VerifyOptions{
+		Strict: saml.Strict,
+	}
+	if saml.IdPFingerprint != "" {
+		opts.Fingerprints = []string{saml.IdPFingerprint}
+	}
+	if saml.IdPCert != "" {
+		buf, err := ioutil.ReadFile(GetPath(saml.IdPCert))
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read idp_cert: %s", err.Error())
+		}
+		for block, rest := pem.Decode(buf); block != nil; block, rest = pem.Decode(rest) {
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid idp_cert %s: %s", saml.IdPCert, err.Error())
+			}
+			opts.Certificates = append(opts.Certificates, cert)
+		}
+		if len(opts.Certificates) == 0 {
+			return nil, fmt.Errorf("No certificates found in idp_cert %s", saml.IdPCert)
+		}
+	}
+	return &opts, nil
+}
+
+/*
+ * Returns the MFA config for the given AppID.  Values set for the app override
+ * the global values.
+ */
+func (c *ConfigFile) GetMfaConfig(appid uint32) MfaConfig {
+	mfa := MfaConfig{}
+	if c.Mfa != nil {
+		mfa = *c.Mfa
+	}
+	if c.Apps == nil {
+		return mfa
+	}
+	app, ok := (*c.Apps)[appid]
+	if !ok || app.Mfa == nil {
+		return mfa
+	}
+	if app.Mfa.DeviceId != 0 {
+		mfa.DeviceId = app.Mfa.DeviceId
+	}
+	if len(app.Mfa.Preferences) > 0 {
+		mfa.Preferences = app.Mfa.Preferences
+	}
+	return mfa
+}
+
+/*
+ * Returns our OneLogin Protect push settings.  CLI flags override the
+ * config file and anything left unset uses the defaults in the onelogin package
+ */
+func (c *ConfigFile) GetPushOptions(cli *CLI) onelogin.PushOptions {
+	po := onelogin.PushOptions{}
+	if c.Push != nil {
+		po.Timeout = time.Duration(c.Push.Timeout) * time.Second
+		po.Interval = time.Duration(c.Push.Interval * float64(time.Second))
+		po.Backoff = c.Push.Backoff
+	}
+	if cli.PushTimeout > 0 {
+		po.Timeout = time.Duration(cli.PushTimeout) * time.Second
+	}
+	if cli.PushInterval > 0 {
+		po.Interval = time.Duration(cli.PushInterval * float64(time.Second))
+	}
+	if cli.PushBackoff > 0 {
+		po.Backoff = cli.PushBackoff
+	}
+	return po
+}
+
 /*
  * these structs are all defined in cmd/config.go
  */
@@ -21,8 +21,6 @@ package main
 import (
 	"os"
 	"os/exec"
-
-	log "github.com/sirupsen/logrus"
 )
 
 type ExecCmd struct {
@@ -37,7 +35,7 @@ func (e *ExecCmd) Run(ctx *RunContext) error {
 	cli := *ctx.Cli
 	session, err := GetSession(ctx, cli.Exec.Profile)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
 	// set our ENV & execute the command
@@ -50,6 +48,9 @@ func (e *ExecCmd) Run(ctx *RunContext) error {
 	os.Setenv("AWS_SESSION_EXPIRATION", session.Expiration.String())
 	os.Setenv("AWS_ENABLED_PROFILE", cli.Exec.Profile)
 	os.Setenv("AWS_ROLE_ARN", session.RoleARN)
+	if session.RoleSessionName != "" {
+		os.Setenv("AWS_ROLE_SESSION_NAME", session.RoleSessionName)
+	}
 
 	// ready our command and connect everything up
 	cmd := exec.Command(cli.Exec.Cmd, cli.Exec.Args...)
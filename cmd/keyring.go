@@ -22,10 +22,13 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/99designs/keyring"
 	"github.com/synfinatic/onelogin-aws-role/aws"
+	"github.com/synfinatic/onelogin-aws-role/onelogin"
+	"github.com/synfinatic/onelogin-aws-role/utils"
 	"golang.org/x/crypto/ssh/terminal"
 )
 
@@ -43,7 +46,7 @@ var krConfigDefaults = keyring.Config{
 	KeychainAccessibleWhenUnlocked: false,
 	// KeychainPasswordFunc: ???,
 	// Other systems below this line
-	FileDir:                 "~/.onelogin-aws-role/keys/",
+	FileDir:                 "", // utils.KeysDir()
 	FilePasswordFunc:        fileKeyringPassphrasePrompt,
 	LibSecretCollectionName: "oneloginawsrole",
 	KWalletAppID:            "onelogin-aws-role",
@@ -67,7 +70,9 @@ func fileKeyringPassphrasePrompt(prompt string) (string, error) {
 
 func OpenKeyring(cfg *keyring.Config) (*KeyringCache, error) {
 	if cfg == nil {
-		cfg = &krConfigDefaults
+		defaults := krConfigDefaults
+		defaults.FileDir = utils.KeysDir()
+		cfg = &defaults
 	}
 	ring, err := keyring.Open(*cfg)
 	if err != nil {
@@ -106,8 +111,16 @@ func (kr *KeyringCache) GetSTSSession(profile string, session *aws.STSSession) e
 	return nil
 }
 
-func (kr *KeyringCache) GetOauthConfig(oauth *OauthConfig) error {
-	data, err := kr.keyring.Get("oauth:config")
+// The default identity uses the original `oauth:config` key
+func oauthKey(identity string) string {
+	if identity == "" || identity == DEFAULT_IDENTITY {
+		return "oauth:config"
+	}
+	return fmt.Sprintf("oauth:%s", identity)
+}
+
+func (kr *KeyringCache) GetOauthConfig(identity string, oauth *OauthConfig) error {
+	data, err := kr.keyring.Get(oauthKey(identity))
 	if err != nil {
 		return err
 	}
@@ -118,18 +131,77 @@ func (kr *KeyringCache) GetOauthConfig(oauth *OauthConfig) error {
 	return nil
 }
 
-func (kr *KeyringCache) SaveOauthConfig(oauth OauthConfig) error {
+func (kr *KeyringCache) SaveOauthConfig(identity string, oauth OauthConfig) error {
 	jdata, err := json.Marshal(oauth)
 	if err != nil {
 		return err
 	}
 	err = kr.keyring.Set(keyring.Item{
-		Key:  "oauth:config",
+		Key:  oauthKey(identity),
+		Data: jdata,
+	})
+	return err
+}
+
+// Save the TOTP seed for the given MFA device in the key chain
+func (kr *KeyringCache) SaveTOTPConfig(device_id int32, totp onelogin.TOTPConfig) error {
+	jdata, err := json.Marshal(totp)
+	if err != nil {
+		return err
+	}
+	err = kr.keyring.Set(keyring.Item{
+		Key:  fmt.Sprintf("mfa:totp:%d", device_id),
 		Data: jdata,
 	})
 	return err
 }
 
+// Get the TOTP seed for the given MFA device from the key chain
+func (kr *KeyringCache) GetTOTPConfig(device_id int32) (*onelogin.TOTPConfig, error) {
+	data, err := kr.keyring.Get(fmt.Sprintf("mfa:totp:%d", device_id))
+	if err != nil {
+		return nil, err
+	}
+	totp := onelogin.TOTPConfig{}
+	err = json.Unmarshal(data.Data, &totp)
+	if err != nil {
+		return nil, err
+	}
+	if err = totp.Validate(); err != nil {
+		return nil, fmt.Errorf("Invalid TOTP seed for MFA device %d: %s", device_id, err.Error())
+	}
+	return &totp, nil
+}
+
+func passwordKey(username string, subdomain string) string {
+	return fmt.Sprintf("password:%s@%s", username, subdomain)
+}
+
+// Save the user's OneLogin password in the key chain
+func (kr *KeyringCache) SavePassword(username string, subdomain string, password string) error {
+	return kr.keyring.Set(keyring.Item{
+		Key:  passwordKey(username, subdomain),
+		Data: []byte(password),
+	})
+}
+
+// Get the user's OneLogin password from the key chain
+func (kr *KeyringCache) GetPassword(username string, subdomain string) (string, error) {
+	data, err := kr.keyring.Get(passwordKey(username, subdomain))
+	if err != nil {
+		return "", err
+	}
+	if len(data.Data) == 0 {
+		return "", fmt.Errorf("No password stored for %s@%s", username, subdomain)
+	}
+	return string(data.Data), nil
+}
+
+// Can't just call keyring.Remove() because it's broken, so we zero out the password instead
+func (kr *KeyringCache) RemovePassword(username string, subdomain string) error {
+	return kr.SavePassword(username, subdomain, "")
+}
+
 func (kr *KeyringCache) RemoveSTSSession(profile string) error {
 	keys, err := kr.keyring.Keys()
 	if err != nil {
@@ -161,3 +233,115 @@ func (kr *KeyringCache) RemoveSTSSession(profile string) error {
 	session.SecretAccessKey = ""
 	return kr.SaveSTSSession(profile, session)
 }
+
+/*
+ * Get the key used to encrypt our cache file, creating it if necessary.
+ * Returns true if the key was created.
+ */
+func (kr *KeyringCache) GetCacheKey() ([]byte, bool, error) {
+	data, err := kr.keyring.Get("cache:key")
+	if err == nil && len(data.Data) == onelogin.CACHE_KEY_LEN {
+		return data.Data, false, nil
+	} else if err != nil && err != keyring.ErrKeyNotFound {
+		return nil, false, err
+	}
+
+	key, err := onelogin.NewCacheKey()
+	if err != nil {
+		return nil, false, err
+	}
+	err = kr.keyring.Set(keyring.Item{
+		Key:  "cache:key",
+		Data: key,
+	})
+	return key, err == nil, err
+}
+
+/*
+ * Stores the OneLogin cache in the key chain.  Each SAML assertion and
+ * the AccessToken is a separate item.
+ */
+type KeyringCacheBackend struct {
+	kr       *KeyringCache
+	identity string
+}
+
+func NewKeyringCacheBackend(kr *KeyringCache, identity string) *KeyringCacheBackend {
+	return &KeyringCacheBackend{
+		kr:       kr,
+		identity: identity,
+	}
+}
+
+func (kcb *KeyringCacheBackend) assertionPrefix() string {
+	return fmt.Sprintf("cache:%s:assertion:", kcb.identity)
+}
+
+func (kcb *KeyringCacheBackend) tokenKey() string {
+	return fmt.Sprintf("cache:%s:accesstoken", kcb.identity)
+}
+
+func (kcb *KeyringCacheBackend) Read() (*onelogin.OneLoginCache, error) {
+	c := onelogin.OneLoginCache{
+		Assertion: map[string]onelogin.SAMLAssertion{},
+	}
+	keys, err := kcb.kr.keyring.Keys()
+	if err != nil {
+		return &c, err
+	}
+
+	prefix := kcb.assertionPrefix()
+	for _, key := range keys {
+		if key == kcb.tokenKey() {
+			data, err := kcb.kr.keyring.Get(key)
+			if err == nil {
+				err = json.Unmarshal(data.Data, &c.AccessToken)
+			}
+			if err != nil {
+				return &c, err
+			}
+		} else if strings.HasPrefix(key, prefix) {
+			data, err := kcb.kr.keyring.Get(key)
+			if err != nil {
+				return &c, err
+			}
+			if len(data.Data) == 0 {
+				continue // removed
+			}
+			assertion := onelogin.SAMLAssertion{}
+			err = json.Unmarshal(data.Data, &assertion)
+			if err != nil {
+				return &c, err
+			}
+			c.Assertion[strings.TrimPrefix(key, prefix)] = assertion
+		}
+	}
+	return &c, nil
+}
+
+func (kcb *KeyringCacheBackend) Write(c *onelogin.OneLoginCache, assertions []string, token bool) error {
+	for _, id := range assertions {
+		jdata, err := json.Marshal(c.Assertion[id])
+		if err != nil {
+			return err
+		}
+		err = kcb.kr.keyring.Set(keyring.Item{
+			Key:  kcb.assertionPrefix() + id,
+			Data: jdata,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if token {
+		jdata, err := json.Marshal(c.AccessToken)
+		if err != nil {
+			return err
+		}
+		return kcb.kr.keyring.Set(keyring.Item{
+			Key:  kcb.tokenKey(),
+			Data: jdata,
+		})
+	}
+	return nil
+}
@@ -33,7 +33,7 @@ import (
  */
 
 type ListCmd struct {
-	Fields     []string `kong:"optional,arg,enum='AccountId,AccountName,AppId,AppName,AppAlias,Arn,Expires,Profile,Region',help='Fields to display (default: AppAlias AccountName RoleAlias Arn Expires)'"`
+	Fields     []string `kong:"optional,arg,enum='AccountId,AccountName,AppId,AppName,AppAlias,Identity,Partition,Arn,Expires,SessionName,SourceProfile,Profile,Region',help='Fields to display (default: AppAlias AccountName RoleAlias Arn Expires)'"`
 	ListFields bool     `kong:"optional,short='f',help='List available fields'"`
 }
 
@@ -102,6 +102,7 @@ func (cc *ListCmd) Run(ctx *RunContext) error {
 			err := kr.GetSTSSession(fc.Profile, &session)
 			if err == nil {
 				fc.Expires = session.GetExpireTimeString()
+				fc.SessionName = session.RoleSessionName
 			}
 		}
 		if fc.Expires == "" {
@@ -19,14 +19,19 @@ package main
  */
 
 import (
+	"errors"
 	"fmt"
+	"os"
+	"strings"
+	"time"
 
-	"github.com/Songmu/prompter"
 	"github.com/alecthomas/kong"
 	"github.com/davecgh/go-spew/spew"
 	log "github.com/sirupsen/logrus"
 	"github.com/synfinatic/onelogin-aws-role/aws"
 	"github.com/synfinatic/onelogin-aws-role/onelogin"
+	"github.com/synfinatic/onelogin-aws-role/utils"
+	"golang.org/x/crypto/ssh/terminal"
 )
 
 // These variables are defined in the Makefile
@@ -36,6 +41,14 @@ var Tag = "NO-TAG"
 var CommitID = "unknown"
 var Delta = ""
 
+// How long we wait for another process to login before doing it ourselves
+const LOGIN_LOCK_TIMEOUT = 3 * time.Minute
+
+// Exit codes
+const (
+	EXIT_NO_TTY = 3 // we need to prompt the user, but there is no TTY
+)
+
 type RunContext struct {
 	OneLogin *onelogin.OneLogin
 	Kctx     *kong.Context
@@ -47,20 +60,34 @@ type CLI struct {
 	// Common Arguments
 	LogLevel string `kong:"optional,short='L',name='loglevel',default='info',enum='error,warn,info,debug',help='Logging level [error|warn|info|debug]'"`
 	Lines    bool   `kong:"optional,name='lines',help='Print line number in logs'"`
-	// have to hard code CONFIG_YAML value here because no way to do string interpolation in a strcture tag.
-	ConfigFile string `kong:"optional,short='c',name='config',default='~/.onelogin-aws-role.yaml',help='Config file'"`
+	// default is set in main() because it depends on $ONELOGIN_AWS_ROLE_HOME & $XDG_CONFIG_HOME
+	ConfigFile string `kong:"optional,short='c',name='config',env='ONELOGIN_AWS_ROLE_CONFIG',help='Config file (see the paths command)'"`
 	// AWS Params
 	Region    string `kong:"optional,short='r',help='AWS Region',env='AWS_DEFAULT_REGION'"`
 	Duration  int64  `kong:"optional,short='d',help='AWS Session duration in minutes (default 60)',default=60,env=ONELOGIN_AWS_DURATION"`
 	PromptMfa bool   `kong:"optional,short='m',name='prompt-mfa',help='Force prompt for which MFA to use'"`
+	// One-off session policy, replaces the policy in the config file
+	PolicyFile string `kong:"optional,name='policy-file',help='Session policy JSON file to restrict the AWS credentials (not cached)'"`
+	// OneLogin Protect push
+	PushTimeout  int64   `kong:"optional,name='push-timeout',help='Seconds to wait for OneLogin Protect push approval (default 60)'"`
+	PushInterval float64 `kong:"optional,name='push-interval',help='Seconds between OneLogin Protect push status checks (default 1)'"`
+	PushBackoff  float64 `kong:"optional,name='push-backoff',help='Multiplier applied to --push-interval after each check (default 1.0)'"`
+	// OneLogin password
+	PasswordStdin   bool   `kong:"optional,name='password-stdin',help='Read OneLogin password from stdin'"`
+	PasswordCommand string `kong:"optional,name='password-command',help='Command which prints your OneLogin password on stdout'"`
 
 	// Commands
 	//	Role RoleCmd `kong:"cmd,help='Fetch & cache AWS STS Token for a given Role/Profile'"`
 	//	App   AppCmd   `kong:"cmd,help='Fetch & cache all AWS STS Tokens for a given OneLogin AppID'"`
-	Exec   ExecCmd   `kong:"cmd,help='Execute command using specified AWS Role/Profile'"`
-	List   ListCmd   `kong:"cmd,help='List all role / appid aliases (default command)',default='1'"`
-	Oauth  OauthCmd  `kong:"cmd,help='Manage OneLogin Oauth credentials'"`
-	Expire ExpireCmd `kong:"cmd,help='Force expire of AWS Role/Profile credentials from keychain'"`
+	Exec      ExecCmd      `kong:"cmd,help='Execute command using specified AWS Role/Profile'"`
+	List      ListCmd      `kong:"cmd,help='List all role / appid aliases (default command)',default='1'"`
+	Oauth     OauthCmd     `kong:"cmd,help='Manage OneLogin Oauth credentials'"`
+	Expire    ExpireCmd    `kong:"cmd,help='Force expire of AWS Role/Profile credentials from keychain'"`
+	Mfa       MfaCmd       `kong:"cmd,help='Manage MFA devices'"`
+	Password  PasswordCmd  `kong:"cmd,help='Manage OneLogin password stored in keychain'"`
+	Apps      AppsCmd      `kong:"cmd,help='Manage OneLogin apps'"`
+	Paths     PathsCmd     `kong:"cmd,help='Print the location of our files'"`
+	Assertion AssertionCmd `kong:"cmd,help='Inspect SAML assertions'"`
 	// Revoke -- much later
 	Version VersionCmd `kong:"cmd,help='Print version and exit'"`
 }
@@ -91,14 +118,18 @@ func main() {
 	cli := CLI{}
 	ctx := parse_args(&cli)
 
+	utils.MigrateLegacyPaths()
+	if cli.ConfigFile == "" {
+		utils.MigrateLegacyConfig()
+		cli.ConfigFile = utils.ConfigFile()
+	}
+
 	c, err := LoadConfigFile(GetPath(cli.ConfigFile))
-	if err != nil {
+	if err != nil && (ctx.Command() == "paths" || (strings.HasPrefix(ctx.Command(), "assertion inspect") && cli.Assertion.Inspect.App == "")) {
+		c = &ConfigFile{} // we don't need a valid config to show our paths or inspect a file
+	} else if err != nil {
 		log.Fatalf("Unable to load config: %s", err.Error())
 	}
-	if cli.PromptMfa {
-		// forget we have a MFA defined
-		c.Mfa = 0
-	}
 	// c.MergeCLI(&cli)
 
 	run_ctx := RunContext{
@@ -108,12 +139,32 @@ func main() {
 		Config:   c,
 	}
 	err = ctx.Run(&run_ctx)
-	if err != nil {
+	if errors.Is(err, ErrNoTTY) || errors.Is(err, onelogin.ErrNoTTY) {
+		log.Errorf("Error running command: %s", err.Error())
+		os.Exit(EXIT_NO_TTY)
+	} else if err != nil {
 		log.Fatalf("Error running command: %s", err.Error())
 	}
 }
 
 func GetSession(ctx *RunContext, profile string) (aws.STSSession, error) {
+	if ctx.Cli.PolicyFile != "" {
+		// one-off session policies would poison our cached session
+		policy, err := GetSessionPolicy(ctx, profile)
+		if err != nil {
+			return aws.STSSession{}, err
+		}
+		session, err := newSession(ctx, nil, profile, policy, map[string]bool{})
+		if err != nil {
+			return session, fmt.Errorf("Unable to get STSSession: %w", err)
+		}
+		return session, nil
+	}
+	return getSession(ctx, profile, map[string]bool{})
+}
+
+// seen tracks the profiles in our role chain so we can detect loops
+func getSession(ctx *RunContext, profile string, seen map[string]bool) (aws.STSSession, error) {
 	session := aws.STSSession{}
 	kr, err := OpenKeyring(nil)
 	if err != nil {
@@ -128,10 +179,19 @@ func GetSession(ctx *RunContext, profile string) (aws.STSSession, error) {
 		}
 	}
 
-	if session.Expired() {
-		session, err = Login(ctx, profile)
+	policy, err := ctx.Config.GetSessionPolicy(profile)
+	if err != nil {
+		return session, err
+	}
+	stale := !session.Expired() && session.PolicyHash != policy.Hash()
+	if stale {
+		log.Warn("Session policy has changed since the STS SessionToken was cached")
+	}
+
+	if session.Expired() || stale {
+		session, err = newSession(ctx, kr, profile, policy, seen)
 		if err != nil {
-			log.WithError(err).Fatal("Unable to get STSSession")
+			return session, fmt.Errorf("Unable to get STSSession: %w", err)
 		}
 		err = kr.SaveSTSSession(profile, session)
 		if err != nil {
@@ -141,78 +201,339 @@ func GetSession(ctx *RunContext, profile string) (aws.STSSession, error) {
 	return session, nil
 }
 
-func Login(ctx *RunContext, profile string) (aws.STSSession, error) {
-	cli := *ctx.Cli
-	kr, err := OpenKeyring(nil)
+// Returns the session policy for the role, using --policy-file if given
+func GetSessionPolicy(ctx *RunContext, profile string) (aws.SessionPolicy, error) {
+	policy, err := ctx.Config.GetSessionPolicy(profile)
+	if err != nil || ctx.Cli.PolicyFile == "" {
+		return policy, err
+	}
+	policy.Policy, err = ReadPolicy(ctx.Cli.PolicyFile)
+	return policy, err
+}
+
+// Returns a new session via role chaining or SAML.  kr may be nil
+func newSession(ctx *RunContext, kr *KeyringCache, profile string, policy aws.SessionPolicy, seen map[string]bool) (aws.STSSession, error) {
+	role, err := ctx.Config.GetRoleConfig(profile)
+	if err == nil && role.SourceProfile != "" {
+		return ChainRole(ctx, role, policy, seen)
+	}
+	return lockedLogin(ctx, kr, profile, policy)
+}
+
+// Login via SAML while holding the login lock for the OneLogin app
+func lockedLogin(ctx *RunContext, kr *KeyringCache, profile string, policy aws.SessionPolicy) (aws.STSSession, error) {
+	lock, waited, err := lockLogin(ctx, profile)
 	if err != nil {
-		return aws.STSSession{}, fmt.Errorf("Unable to open KeyChain for OneLogin Oauth: %s", err)
+		log.WithError(err).Warn("Unable to coordinate login with other processes")
+	} else {
+		defer lock.Unlock()
 	}
+	if waited && kr != nil {
+		// the other process may have already fetched our session
+		session := aws.STSSession{}
+		err = kr.GetSTSSession(profile, &session)
+		if err == nil && !session.Expired() && session.PolicyHash == policy.Hash() {
+			return session, nil
+		}
+	}
+	return Login(ctx, profile, policy)
+}
+
+/*
+ * Returns the session for a role with a source_profile by calling AssumeRole
+ * with the session of the source profile, which may itself be chained.
+ */
+func ChainRole(ctx *RunContext, role *RoleConfig, policy aws.SessionPolicy, seen map[string]bool) (aws.STSSession, error) {
+	if seen[role.Profile] {
+		return aws.STSSession{}, fmt.Errorf("Loop in source_profile for role: %s", role.Profile)
+	}
+	seen[role.Profile] = true
+
+	source, err := getSession(ctx, role.SourceProfile, seen)
+	if err != nil {
+		return aws.STSSession{}, fmt.Errorf("Unable to get source_profile %s: %w", role.SourceProfile, err)
+	}
+	region, err := GetRegion(ctx, role.Profile, role.Arn)
+	if err != nil {
+		return aws.STSSession{}, err
+	}
+	client, err := ctx.Config.GetHTTPClient()
+	if err != nil {
+		return aws.STSSession{}, err
+	}
+	return aws.AssumeRole(source, aws.AssumeRoleOptions{
+		STSOptions: aws.STSOptions{
+			RoleARN:  role.Arn,
+			Region:   region,
+			Duration: ctx.Cli.Duration * 60,
+			Policy:   policy,
+			Endpoint: ctx.Config.GetSTSEndpoint(role.Profile),
+			Client:   client,
+		},
+		ExternalId:  role.ExternalId,
+		SessionName: role.SessionName,
+	})
+}
+
+/*
+ * Only one process should login to a given OneLogin app at a time so the
+ * user isn't prompted repeatedly.  Waits up to LOGIN_LOCK_TIMEOUT for the lock
+ * and returns true if we had to wait for another process to finish logging in.
+ * After a timeout, the caller logs in without the lock.
+ */
+func lockLogin(ctx *RunContext, profile string) (*utils.FileLock, bool, error) {
+	appid, err := ctx.Config.GetAppIdForRole(profile)
+	if err != nil {
+		return nil, false, err
+	}
+	identity, err := ctx.Config.GetIdentity(ctx.Config.GetAppIdentity(appid))
+	if err != nil {
+		return nil, false, err
+	}
+	path := fmt.Sprintf("%s.%d.lock", identityCacheFile(identity), appid)
+	if err = utils.EnsureDir(path); err != nil {
+		return nil, false, err
+	}
+
+	lock, ok, err := utils.TryLockFile(path)
+	if err != nil || ok {
+		return lock, false, err
+	}
+	// the other login may be stuck at a prompt nobody will answer
+	fmt.Fprintf(os.Stderr, "Waiting up to %s for another onelogin-aws-role to login to OneLogin app %d...\n",
+		LOGIN_LOCK_TIMEOUT, appid)
+	lock, err = utils.LockFileTimeout(path, LOGIN_LOCK_TIMEOUT)
+	return lock, true, err
+}
+
+// Returns the cache file for the identity
+func identityCacheFile(identity *IdentityConfig) string {
+	namespace := ""
+	if identity.Name != DEFAULT_IDENTITY {
+		namespace = identity.Name
+	}
+	return onelogin.CacheFile(namespace)
+}
+
+// Returns the OneLogin cache for the identity using the configured backend
+func OpenCache(ctx *RunContext, kr *KeyringCache, identity *IdentityConfig) (*onelogin.OneLoginCache, error) {
+	var backend onelogin.CacheBackend
+	switch ctx.Config.Cache {
+	case "", CACHE_FILE:
+		backend = onelogin.NewFileCacheBackend(identityCacheFile(identity), nil)
+	case CACHE_ENCRYPTED_FILE:
+		key, created, err := kr.GetCacheKey()
+		if err != nil {
+			return nil, fmt.Errorf("Unable to get cache key from KeyChain: %s", err.Error())
+		}
+		fcb := onelogin.NewFileCacheBackend(identityCacheFile(identity), key)
+		if created {
+			// we just switched to an encrypted cache, so this is our only chance to migrate
+			if err = fcb.Migrate(); err != nil {
+				log.WithError(err).Warn("Unable to encrypt cache file, removing it")
+				removeCacheFile(fcb)
+			}
+		}
+		backend = fcb
+	case CACHE_KEYRING:
+		// don't leave our Oauth token on disk after switching to the KeyChain
+		removeCacheFile(onelogin.NewFileCacheBackend(identityCacheFile(identity), nil))
+		backend = NewKeyringCacheBackend(kr, identity.Name)
+	default:
+		return nil, fmt.Errorf("Invalid cache: %s", ctx.Config.Cache)
+	}
+	return onelogin.NewOneLoginCache(backend), nil
+}
+
+func removeCacheFile(fcb *onelogin.FileCacheBackend) {
+	if err := fcb.Remove(); err != nil {
+		log.WithError(err).Warn("Unable to remove old cache file")
+	}
+}
+
+// Returns a OneLogin client for the identity using the Oauth credentials in our KeyChain
+func ConnectOneLogin(ctx *RunContext, kr *KeyringCache, identity *IdentityConfig) (*onelogin.OneLogin, error) {
 	oauth := OauthConfig{}
-	err = kr.GetOauthConfig(&oauth)
+	err := kr.GetOauthConfig(identity.Name, &oauth)
+	if err != nil {
+		return nil, fmt.Errorf("Please configure Oauth credentials for identity: %s", identity.Name)
+	}
+
+	cache, err := OpenCache(ctx, kr, identity)
 	if err != nil {
-		return aws.STSSession{}, fmt.Errorf("Please configure Oauth credentials")
+		return nil, err
 	}
+	client, err := ctx.Config.GetHTTPClient()
+	if err != nil {
+		return nil, err
+	}
+	return onelogin.NewOneLogin(oauth.ClientId, oauth.Secret, identity.Region, cache, client)
+}
 
-	o, err := onelogin.NewOneLogin(oauth.ClientId, oauth.Secret, ctx.Config.Region)
+func Login(ctx *RunContext, profile string, policy aws.SessionPolicy) (aws.STSSession, error) {
+	cli := *ctx.Cli
+	kr, err := OpenKeyring(nil)
 	if err != nil {
-		log.WithError(err).Fatal("Unable to connect to OneLogin")
+		return aws.STSSession{}, fmt.Errorf("Unable to open KeyChain for OneLogin Oauth: %s", err)
 	}
+
 	log.Debugf("config = %s", spew.Sdump(ctx.Config))
 	appid, err := ctx.Config.GetAppIdForRole(profile)
 	if err != nil {
 		return aws.STSSession{}, err
 	}
+	identity, err := ctx.Config.GetIdentity(ctx.Config.GetAppIdentity(appid))
+	if err != nil {
+		return aws.STSSession{}, err
+	}
 
-	ols := &onelogin.OneLoginSAML{}
+	o, err := ConnectOneLogin(ctx, kr, identity)
+	if err != nil {
+		return aws.STSSession{}, err
+	}
+
+	ols := onelogin.NewOneLoginSAML(o)
 	need_mfa := false
-	passwd_auth_pass := false
+	// no need for the password if we have a valid SAML assertion
+	_, err = o.Cache.GetAssertion(appid)
+	passwd_auth_pass := err == nil
+	tried := map[PasswordSource]bool{}
+	next_passwd := "" // set after changing an expired password
 	for !passwd_auth_pass {
-		passwd := prompter.Password("Enter your OneLogin password")
+		passwd, source := next_passwd, PasswordPrompt
+		if next_passwd == "" {
+			passwd, source, err = GetPassword(ctx, kr, identity, tried)
+			if err != nil {
+				return aws.STSSession{}, err
+			}
+			tried[source] = true
+		}
+		next_passwd = ""
 
 		if passwd == "" {
 			return aws.STSSession{}, fmt.Errorf("OneLogin authentication aborted")
 		}
 		ols = onelogin.NewOneLoginSAML(o)
-		need_mfa, err = ols.GetAssertion(ctx.Config.Username, passwd, ctx.Config.Subdomain, appid, "")
+		need_mfa, err = ols.GetAssertion(identity.Username, passwd, identity.Subdomain, appid, "")
 		if err == nil {
 			passwd_auth_pass = true
+			if identity.RememberPassword && source == PasswordPrompt {
+				err = kr.SavePassword(identity.Username, identity.Subdomain, passwd)
+				if err != nil {
+					log.WithError(err).Warn("Unable to save OneLogin password in KeyChain")
+				}
+			}
+		} else if errors.Is(err, onelogin.ErrPasswordExpired) {
+			next_passwd, err = ChangeExpiredPassword(o, identity)
+			if err != nil {
+				return aws.STSSession{}, err
+			}
+		} else if !source.Interactive() {
+			return aws.STSSession{}, fmt.Errorf("Unable to authenticate with password from %s: %w", source, err)
+		} else if !errors.Is(err, onelogin.ErrInvalidCredentials) {
+			// retrying with another password won't help
+			return aws.STSSession{}, err
+		} else if source == PasswordKeyring {
+			log.Warn("Stored OneLogin password is invalid, removing it from KeyChain")
+			err = kr.RemovePassword(identity.Username, identity.Subdomain)
+			if err != nil {
+				log.WithError(err).Warn("Unable to remove OneLogin password from KeyChain")
+			}
+		} else {
+			log.Error(err.Error())
 		}
 	}
 
 	if need_mfa {
 		fmt.Printf("MFA Required\n")
-		success, err := ols.SubmitMFA(ctx.Config.Mfa, appid)
-		if err != nil {
-			log.Fatal(err)
+		ols.TOTPLookup = kr.GetTOTPConfig
+		ols.PushOptions = ctx.Config.GetPushOptions(ctx.Cli)
+		if terminal.IsTerminal(int(os.Stderr.Fd())) {
+			ols.PushOptions.Progress = pushCountdown
+		}
+		var deviceId int32 = 0
+		if !cli.PromptMfa {
+			mfaConfig := ctx.Config.GetMfaConfig(appid)
+			deviceId = ols.PickMfaDevice(mfaConfig.DeviceId, mfaConfig.Preferences)
+		}
+		success, err := ols.SubmitMFA(deviceId, appid)
+		if ols.PushOptions.Progress != nil {
+			// clear our countdown
+			fmt.Fprintf(os.Stderr, "\r%s\r", strings.Repeat(" ", 60))
+		}
+		if errors.Is(err, onelogin.ErrPushDenied) {
+			return aws.STSSession{}, fmt.Errorf("OneLogin Protect push was denied on your phone: %w", err)
+		} else if errors.Is(err, onelogin.ErrPushTimeout) {
+			return aws.STSSession{}, fmt.Errorf("Timed out waiting for OneLogin Protect push approval: %w", err)
+		} else if err != nil {
+			return aws.STSSession{}, err
 		}
 		if !success {
-			log.Fatalf("MFA auth failed.")
+			return aws.STSSession{}, onelogin.ErrMFAFailed
 		}
 	}
 	assertion, err := ols.OneLogin.Cache.GetAssertion(appid)
 	if err != nil {
-		log.Fatalf("Unable to get SAML Assertion: %s", err.Error())
+		return aws.STSSession{}, fmt.Errorf("Unable to get SAML Assertion: %s", err.Error())
 	} else {
 		log.Debugf("Got SAML Assertion:\n%s", assertion)
 	}
 
 	role, err := ctx.Config.GetRoleArn(profile)
 	if err != nil {
-		log.Fatal(err)
+		return aws.STSSession{}, err
 	}
 
-	var region string
-	if cli.Region != "" {
-		region = cli.Region
-	} else {
-		region, err = ctx.Config.GetRoleRegion(profile)
+	verify, err := ctx.Config.GetVerifyOptions(appid)
+	if err != nil {
+		return aws.STSSession{}, err
+	} else if verify != nil {
+		verify.RoleARN = role
+		err = aws.VerifyAssertion(assertion, *verify)
 		if err != nil {
-			log.WithError(err).Warn("Unable to set default AWS region, falling back to us-east-1")
-			region = "us-east-1"
+			return aws.STSSession{}, err
+		}
+	}
+
+	region, err := GetRegion(ctx, profile, role)
+	if err != nil {
+		return aws.STSSession{}, err
+	}
+
+	client, err := ctx.Config.GetHTTPClient()
+	if err != nil {
+		return aws.STSSession{}, err
+	}
+	return aws.GetSTSSession(assertion, aws.STSOptions{
+		RoleARN:  role,
+		Region:   region,
+		Duration: cli.Duration * 60,
+		Policy:   policy,
+		Endpoint: ctx.Config.GetSTSEndpoint(profile),
+		Client:   client,
+	})
+}
+
+// Returns the AWS region to use for the role: --region, the role's default or the partition's
+func GetRegion(ctx *RunContext, profile string, role string) (string, error) {
+	if ctx.Cli.Region != "" {
+		return ctx.Cli.Region, nil
+	}
+	region, err := ctx.Config.GetRoleRegion(profile)
+	if err != nil {
+		partition, perr := aws.PartitionFromARN(role)
+		if perr != nil {
+			return "", perr
 		}
+		region = partition.DefaultRegion
+		log.WithError(err).Warnf("Unable to set default AWS region, falling back to %s", region)
 	}
+	return region, nil
+}
 
-	return aws.GetSTSSession(assertion, role, region, cli.Duration*60)
+// Displays a live countdown while we wait for the user to approve the push
+func pushCountdown(remaining time.Duration) {
+	fmt.Fprintf(os.Stderr, "\rWaiting for OneLogin Protect approval: %3ds remaining ", int(remaining.Round(time.Second).Seconds()))
 }
 
 type VersionCmd struct {
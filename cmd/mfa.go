@@ -0,0 +1,112 @@
+package main
+
+/*
+ * OneLogin AWS Role
+ * Copyright (c) 2020-2021 Aaron Turner  <aturner at synfin dot net>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"fmt"
+
+	"github.com/Songmu/prompter"
+	log "github.com/sirupsen/logrus"
+	"github.com/synfinatic/onelogin-aws-role/onelogin"
+	"github.com/synfinatic/onelogin-aws-role/utils"
+)
+
+type MfaCmd struct {
+	List       MfaListCmd       `kong:"cmd,help='Login to OneLogin and list your MFA devices'"`
+	EnrollTotp MfaEnrollTotpCmd `kong:"cmd,name='enroll-totp',help='Store the TOTP seed for an authenticator app MFA device'"`
+}
+
+type MfaListCmd struct {
+	App string `kong:"optional,short='a',name='app',help='OneLogin AppID alias or number to login with (default: lowest AppID)'"`
+}
+
+func (mc *MfaListCmd) Run(ctx *RunContext) error {
+	cli := *ctx.Cli
+	appid, err := ctx.Config.GetAppId(cli.Mfa.List.App)
+	if err != nil {
+		return err
+	}
+
+	kr, err := OpenKeyring(nil)
+	if err != nil {
+		return fmt.Errorf("Unable to open KeyChain for OneLogin Oauth: %s", err)
+	}
+	identity, err := ctx.Config.GetIdentity(ctx.Config.GetAppIdentity(appid))
+	if err != nil {
+		return err
+	}
+	o, err := ConnectOneLogin(ctx, kr, identity)
+	if err != nil {
+		return err
+	}
+
+	passwd, _, err := GetPassword(ctx, kr, identity, map[PasswordSource]bool{})
+	if err != nil {
+		return err
+	}
+	if passwd == "" {
+		return fmt.Errorf("OneLogin authentication aborted")
+	}
+	ols := onelogin.NewOneLoginSAML(o)
+	need_mfa, err := ols.RequestAssertion(identity.Username, passwd, identity.Subdomain, appid, "")
+	if err != nil {
+		return err
+	}
+	if !need_mfa {
+		fmt.Printf("MFA is not required to access AppID %d\n", appid)
+		return nil
+	}
+
+	ts := []utils.TableStruct{}
+	for _, device := range ols.Response.Devices {
+		ts = append(ts, device)
+	}
+	utils.GenerateTable(ts, []string{"DeviceType", "DeviceId"})
+	return nil
+}
+
+type MfaEnrollTotpCmd struct {
+	DeviceId int32  `kong:"arg,required,name='device_id',help='OneLogin MFA device_id'"`
+	Seed     string `kong:"arg,optional,name='seed',help='Base32 TOTP seed or otpauth:// URI (default: prompt)'"`
+}
+
+func (mc *MfaEnrollTotpCmd) Run(ctx *RunContext) error {
+	cli := *ctx.Cli
+	kr, err := OpenKeyring(nil)
+	if err != nil {
+		return fmt.Errorf("Unable to open KeyChain: %s", err)
+	}
+
+	seed := cli.Mfa.EnrollTotp.Seed
+	if seed == "" {
+		seed = prompter.Password("TOTP seed or otpauth:// URI")
+	}
+
+	totp, err := onelogin.ParseTOTP(seed)
+	if err != nil {
+		return err
+	}
+
+	err = kr.SaveTOTPConfig(cli.Mfa.EnrollTotp.DeviceId, *totp)
+	if err != nil {
+		return err
+	}
+	log.Infof("Stored TOTP seed for MFA device %d", cli.Mfa.EnrollTotp.DeviceId)
+	return nil
+}
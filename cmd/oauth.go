@@ -28,8 +28,9 @@ import (
 )
 
 type OauthCmd struct {
-	Show OauthShowCmd `kong:"cmd,help='Show Oauth UserId/Secret',default='1'"`
-	Set  OauthSetCmd  `kong:"cmd,help='Set Oauth UserId/Secret'"`
+	Identity string       `kong:"optional,short='i',name='identity',default='default',help='OneLogin identity to manage'"`
+	Show     OauthShowCmd `kong:"cmd,help='Show Oauth UserId/Secret',default='1'"`
+	Set      OauthSetCmd  `kong:"cmd,help='Set Oauth UserId/Secret'"`
 }
 
 type OauthSetCmd struct{}
@@ -47,7 +48,7 @@ func (oc *OauthShowCmd) Run(ctx *RunContext) error {
 	}
 
 	oauth := &OauthConfig{}
-	err = kr.GetOauthConfig(oauth)
+	err = kr.GetOauthConfig(ctx.Cli.Oauth.Identity, oauth)
 	if err != nil {
 		return fmt.Errorf("Unable to get OauthConfig: %s", err)
 	}
@@ -61,6 +62,10 @@ func (oc *OauthShowCmd) Run(ctx *RunContext) error {
 }
 
 func (oc *OauthSetCmd) Run(ctx *RunContext) error {
+	_, err := ctx.Config.GetIdentity(ctx.Cli.Oauth.Identity)
+	if err != nil {
+		return err
+	}
 	kr, err := OpenKeyring(nil)
 	if err != nil {
 		return err
@@ -88,7 +93,7 @@ func (oc *OauthSetCmd) Run(ctx *RunContext) error {
 		ClientId: clientid,
 		Secret:   secret,
 	}
-	return kr.SaveOauthConfig(o)
+	return kr.SaveOauthConfig(ctx.Cli.Oauth.Identity, o)
 }
 
 // Necessary for util.GenerateTable
@@ -0,0 +1,242 @@
+package main
+
+/*
+ * OneLogin AWS Role
+ * Copyright (c) 2020-2021 Aaron Turner  <aturner at synfin dot net>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"github.com/Songmu/prompter"
+	log "github.com/sirupsen/logrus"
+	"github.com/synfinatic/onelogin-aws-role/onelogin"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+const PASSWORD_ENV = "ONELOGIN_AWS_PASSWORD"
+
+// We need to prompt the user for their password, but can't
+var ErrNoTTY = errors.New("Unable to prompt for OneLogin password without a TTY")
+
+// Where we got the OneLogin password from
+type PasswordSource int
+
+const (
+	PasswordPrompt PasswordSource = iota
+	PasswordStdin
+	PasswordEnv
+	PasswordCommand
+	PasswordKeyring
+)
+
+func (ps PasswordSource) String() string {
+	switch ps {
+	case PasswordStdin:
+		return "stdin"
+	case PasswordEnv:
+		return fmt.Sprintf("$%s", PASSWORD_ENV)
+	case PasswordCommand:
+		return "password_command"
+	case PasswordKeyring:
+		return "KeyChain"
+	}
+	return "prompt"
+}
+
+// Non-interactive sources can't give us a different password if it is wrong
+func (ps PasswordSource) Interactive() bool {
+	return ps == PasswordPrompt || ps == PasswordKeyring
+}
+
+/*
+ * Returns the OneLogin password and where it came from.  Sources are used in
+ * this order: --password-stdin, $ONELOGIN_AWS_PASSWORD, password_command,
+ * the KeyChain (if remember_password is set) and finally prompting the user.
+ * Sources listed in `tried` are skipped.
+ */
+func GetPassword(ctx *RunContext, kr *KeyringCache, identity *IdentityConfig, tried map[PasswordSource]bool) (string, PasswordSource, error) {
+	cli := ctx.Cli
+	if cli.PasswordStdin && !tried[PasswordStdin] {
+		buf, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return "", PasswordStdin, fmt.Errorf("Unable to read password from stdin: %s", err.Error())
+		}
+		reopenTTY()
+		return trimNewline(string(buf)), PasswordStdin, nil
+	}
+
+	if passwd := os.Getenv(PASSWORD_ENV); passwd != "" && !tried[PasswordEnv] {
+		return passwd, PasswordEnv, nil
+	}
+
+	command := identity.PasswordCommand
+	if cli.PasswordCommand != "" {
+		command = cli.PasswordCommand
+	}
+	if command != "" && !tried[PasswordCommand] {
+		passwd, err := runPasswordCommand(command)
+		return passwd, PasswordCommand, err
+	}
+
+	if identity.RememberPassword && kr != nil && !tried[PasswordKeyring] {
+		passwd, err := kr.GetPassword(identity.Username, identity.Subdomain)
+		if err == nil {
+			return passwd, PasswordKeyring, nil
+		}
+		log.WithError(err).Debug("Unable to read OneLogin password from KeyChain")
+	}
+
+	if !terminal.IsTerminal(int(os.Stdin.Fd())) {
+		return "", PasswordPrompt, ErrNoTTY
+	}
+	return prompter.Password("Enter your OneLogin password"), PasswordPrompt, nil
+}
+
+/*
+ * Offers to change the user's expired OneLogin password and returns the
+ * new password so we can retry the login with it.
+ *
+ * OneLogin's API has no call for users to change their own password, only
+ * the admin "Set Password" calls, so this requires Oauth credentials with the
+ * "Manage users" scope.  We only ever change the password of the user who just
+ * logged in with their current password.  Otherwise, the user is told to
+ * change it via the OneLogin portal.
+ */
+func ChangeExpiredPassword(o *onelogin.OneLogin, identity *IdentityConfig) (string, error) {
+	portal := fmt.Errorf("Please change your password at https://%s.onelogin.com and try again: %w",
+		identity.Subdomain, onelogin.ErrPasswordExpired)
+	if !terminal.IsTerminal(int(os.Stdin.Fd())) {
+		return "", portal
+	}
+	if !prompter.YN("Your OneLogin password has expired.  Change it now?", true) {
+		return "", portal
+	}
+
+	passwd := prompter.Password("Enter your new OneLogin password")
+	if passwd == "" {
+		return "", fmt.Errorf("OneLogin password must not be empty")
+	}
+	if passwd != prompter.Password("Confirm your new OneLogin password") {
+		return "", fmt.Errorf("OneLogin passwords do not match")
+	}
+
+	user, err := o.GetUser(identity.Username)
+	if err != nil {
+		log.WithError(err).Error("Unable to find your OneLogin user")
+		return "", portal
+	}
+	err = o.SetPassword(user.Id, passwd)
+	if err != nil {
+		log.WithError(err).Error("Unable to change your OneLogin password")
+		return "", portal
+	}
+	log.Info("OneLogin password changed")
+	return passwd, nil
+}
+
+// Runs the command via the shell and returns the first line of stdout
+func runPasswordCommand(command string) (string, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	} else {
+		cmd = exec.Command("sh", "-c", command)
+	}
+	stdout := bytes.Buffer{}
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+	cmd.Stdin = os.Stdin
+	err := cmd.Run()
+	if err != nil {
+		return "", fmt.Errorf("Unable to run password_command: %s", err.Error())
+	}
+	return trimNewline(strings.SplitN(stdout.String(), "\n", 2)[0]), nil
+}
+
+/*
+ * --password-stdin consumes stdin, so read any MFA prompts from the terminal
+ * instead.  Without a terminal, only MFA which doesn't prompt will work.
+ */
+func reopenTTY() {
+	name := "/dev/tty"
+	if runtime.GOOS == "windows" {
+		name = "CONIN$"
+	}
+	tty, err := os.Open(name)
+	if err != nil {
+		log.WithError(err).Debug("Unable to open the terminal for MFA prompts")
+		return
+	}
+	os.Stdin = tty
+}
+
+func trimNewline(s string) string {
+	return strings.TrimRight(s, "\r\n")
+}
+
+type PasswordCmd struct {
+	Identity string           `kong:"optional,short='i',name='identity',default='default',help='OneLogin identity to manage'"`
+	Set      PasswordSetCmd   `kong:"cmd,help='Store your OneLogin password in the keychain'"`
+	Clear    PasswordClearCmd `kong:"cmd,help='Remove your OneLogin password from the keychain'"`
+}
+
+type PasswordSetCmd struct{}
+type PasswordClearCmd struct{}
+
+func (pc *PasswordSetCmd) Run(ctx *RunContext) error {
+	identity, err := ctx.Config.GetIdentity(ctx.Cli.Password.Identity)
+	if err != nil {
+		return err
+	}
+	kr, err := OpenKeyring(nil)
+	if err != nil {
+		return fmt.Errorf("Unable to open KeyChain: %s", err)
+	}
+
+	passwd := prompter.Password("Enter your OneLogin password")
+	if passwd == "" {
+		return fmt.Errorf("OneLogin password must not be empty")
+	}
+
+	err = kr.SavePassword(identity.Username, identity.Subdomain, passwd)
+	if err != nil {
+		return err
+	}
+	if !identity.RememberPassword {
+		log.Warn("Set `remember_password: true` in your config file to use the stored password")
+	}
+	return nil
+}
+
+func (pc *PasswordClearCmd) Run(ctx *RunContext) error {
+	identity, err := ctx.Config.GetIdentity(ctx.Cli.Password.Identity)
+	if err != nil {
+		return err
+	}
+	kr, err := OpenKeyring(nil)
+	if err != nil {
+		return fmt.Errorf("Unable to open KeyChain: %s", err)
+	}
+	return kr.RemovePassword(identity.Username, identity.Subdomain)
+}
@@ -0,0 +1,47 @@
+package main
+
+/*
+ * OneLogin AWS Role
+ * Copyright (c) 2020-2021 Aaron Turner  <aturner at synfin dot net>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/synfinatic/onelogin-aws-role/utils"
+)
+
+type PathsCmd struct{}
+
+func (pc *PathsCmd) Run(ctx *RunContext) error {
+	fmt.Printf("Config file:      %s\n", GetPath(ctx.Cli.ConfigFile))
+	fmt.Printf("Cache directory:  %s\n", utils.CacheDir())
+
+	identities := []string{}
+	if ctx.Config.Identities != nil {
+		for name := range *ctx.Config.Identities {
+			identities = append(identities, name)
+		}
+	}
+	sort.Strings(identities)
+	fmt.Printf("Cache file:       %s\n", utils.CacheFile(""))
+	for _, name := range identities {
+		fmt.Printf("  %-15s %s\n", name+":", utils.CacheFile(name))
+	}
+	fmt.Printf("File keyring:     %s\n", utils.KeysDir())
+	return nil
+}
@@ -8,15 +8,20 @@ require (
 	github.com/alecthomas/kong v0.2.15
 	github.com/antchfx/xmlquery v1.3.3
 	github.com/aws/aws-sdk-go v1.36.23
+	github.com/aws/aws-sdk-go-v2 v1.9.0
+	github.com/beevik/etree v1.1.0
 	github.com/davecgh/go-spew v1.1.1
 	github.com/go-resty/resty/v2 v2.3.0
 	github.com/goccy/go-yaml v1.8.4
 	github.com/kr/text v0.2.0 // indirect
 	github.com/niemeyer/pretty v0.0.0-20200227124842-a10e7caefd8e // indirect
+	github.com/russellhaering/goxmldsig v1.4.0
 	github.com/sirupsen/logrus v1.7.0
 	github.com/stretchr/testify v1.6.1 // indirect
 	golang.org/x/crypto v0.0.0-20200820211705-5c72a883971a
-	gopkg.in/check.v1 v1.0.0-20200227125254-8fa46927fb4f // indirect
+	golang.org/x/net v0.0.0-20201110031124-69a78807bb2b
+	golang.org/x/sys v0.0.0-20200930185726-fdedc70b468f
+	gopkg.in/check.v1 v1.0.0-20201130134442-10cb98267c6c // indirect
 )
 
 // pin this version (or later) until 99designs/keyring updates.  See #19
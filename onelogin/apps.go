@@ -0,0 +1,174 @@
+package onelogin
+
+/*
+ * OneLogin AWS Role
+ * Copyright (c) 2020-2021 Aaron Turner  <aturner at synfin dot net>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+/*
+ * Discover which OneLogin apps are assigned to a user.  These API calls require
+ * Oauth credentials with at least the "Read All" scope.
+ */
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"reflect"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/synfinatic/onelogin-aws-role/utils"
+)
+
+const AUTH_METHOD_SAML = 2 // connector/app auth_method for SAML 2.0
+
+// Connector names which are used for AWS
+var AWS_CONNECTOR_NAMES = []string{
+	"*Amazon Web Services*",
+	"*AWS*",
+}
+
+// App as returned by the users/:id/apps API
+type UserApp struct {
+	Id      uint32 `json:"id" header:"AppID"`
+	Name    string `json:"name" header:"App Name"`
+	IconUrl string `json:"icon_url" header:"Icon"`
+	LoginId int64  `json:"login_id" header:"Login ID"`
+}
+
+// App as returned by the apps/:id API
+type App struct {
+	Id          uint32 `json:"id"`
+	ConnectorId int64  `json:"connector_id"`
+	Name        string `json:"name"`
+	AuthMethod  int    `json:"auth_method"`
+	IconUrl     string `json:"icon_url"`
+}
+
+type Connector struct {
+	Id         int64  `json:"id"`
+	Name       string `json:"name"`
+	AuthMethod int    `json:"auth_method"`
+}
+
+// Returns all the apps assigned to the given user
+func (o *OneLogin) GetUserApps(user_id int64) ([]UserApp, error) {
+	url := fmt.Sprintf("%s/api/2/users/%d/apps", o.Url, user_id)
+	apps := []UserApp{}
+	resp, err := o.Client.R().
+		SetResult(&apps).
+		Get(url)
+	if err != nil {
+		return apps, networkError(fmt.Sprintf("get apps for user %d", user_id), err)
+	} else if resp.IsError() {
+		return apps, responseError(fmt.Sprintf("get apps for user %d", user_id), resp, nil)
+	}
+	return apps, nil
+}
+
+// Returns the details of the given app
+func (o *OneLogin) GetApp(app_id uint32) (*App, error) {
+	url := fmt.Sprintf("%s/api/2/apps/%d", o.Url, app_id)
+	resp, err := o.Client.R().
+		SetResult(&App{}).
+		Get(url)
+	if err != nil {
+		return nil, networkError(fmt.Sprintf("get app %d", app_id), err)
+	} else if resp.IsError() {
+		return nil, responseError(fmt.Sprintf("get app %d", app_id), resp, nil)
+	}
+	return resp.Result().(*App), nil
+}
+
+// Returns the connectors matching name, which may contain `*` wildcards
+func (o *OneLogin) GetConnectors(name string) ([]Connector, error) {
+	url := fmt.Sprintf("%s/api/2/connectors", o.Url)
+	connectors := []Connector{}
+	resp, err := o.Client.R().
+		SetQueryParam("name", name).
+		SetResult(&connectors).
+		Get(url)
+	if err != nil {
+		return connectors, networkError("get connectors", err)
+	} else if resp.IsError() {
+		return connectors, responseError("get connectors", resp, nil)
+	}
+	return connectors, nil
+}
+
+// Returns the SAML apps using an AWS connector which are assigned to the user
+func (o *OneLogin) GetUserAWSApps(username string) ([]UserApp, error) {
+	awsApps := []UserApp{}
+	user, err := o.GetUser(username)
+	if err != nil {
+		return awsApps, err
+	}
+
+	connectors := map[int64]string{}
+	for _, name := range AWS_CONNECTOR_NAMES {
+		cons, err := o.GetConnectors(name)
+		if err != nil {
+			return awsApps, err
+		}
+		for _, c := range cons {
+			if c.AuthMethod == AUTH_METHOD_SAML {
+				connectors[c.Id] = c.Name
+			}
+		}
+	}
+
+	apps, err := o.GetUserApps(user.Id)
+	if err != nil {
+		return awsApps, err
+	}
+	for _, app := range apps {
+		details, err := o.GetApp(app.Id)
+		if err != nil {
+			return awsApps, err
+		}
+		if name, ok := connectors[details.ConnectorId]; ok {
+			log.Debugf("App %d (%s) uses connector %s", app.Id, app.Name, name)
+			awsApps = append(awsApps, app)
+		} else if details.AuthMethod == AUTH_METHOD_SAML && strings.Contains(strings.ToLower(details.Name), "aws") {
+			// custom SAML connector which looks like AWS
+			awsApps = append(awsApps, app)
+		}
+	}
+	return awsApps, nil
+}
+
+/*
+ * Returns a short label for the app's icon, such as "amazonwebservices" for
+ * https://cdn.onelogin.com/images/icons/square/amazonwebservices/old_choose.png
+ */
+func (ua UserApp) IconLabel() string {
+	u, err := url.Parse(ua.IconUrl)
+	if err != nil || u.Path == "" {
+		return ""
+	}
+	dir := path.Base(path.Dir(u.Path))
+	if dir != "/" && dir != "." && dir != "icons" && dir != "square" {
+		return dir
+	}
+	file := path.Base(u.Path)
+	return strings.TrimSuffix(file, path.Ext(file))
+}
+
+func (ua UserApp) GetHeader(fieldName string) (string, error) {
+	v := reflect.ValueOf(ua)
+	return utils.GetHeaderTag(v, fieldName)
+}
@@ -19,22 +19,41 @@ package onelogin
  */
 
 import (
-	"encoding/json"
 	"fmt"
-	"os"
 	"time"
 
 	"github.com/synfinatic/onelogin-aws-role/aws"
+	"github.com/synfinatic/onelogin-aws-role/utils"
 
 	log "github.com/sirupsen/logrus"
 )
 
 func saml_cache() string {
-	return fmt.Sprintf("%s/.onelogin-aws-role.cache", os.Getenv("HOME"))
+	return utils.CacheFile("")
+}
+
+/*
+ * Returns the cache file for the given namespace so that multiple OneLogin
+ * accounts don't overwrite each other.  An empty namespace is our default cache.
+ */
+func CacheFile(namespace string) string {
+	return utils.CacheFile(namespace)
+}
+
+/*
+ * Where our cache is persisted.  Write is given the IDs of the assertions
+ * which have changed and if the AccessToken has changed so backends can
+ * avoid overwriting values saved by other copies of onelogin-aws-role.
+ */
+type CacheBackend interface {
+	Read() (*OneLoginCache, error) // a missing cache is not an error
+	Write(c *OneLoginCache, assertions []string, token bool) error
 }
 
 type OneLoginCache struct {
-	filename    string
+	backend     CacheBackend
+	dirty       map[string]bool          // assertions we have changed since loading
+	tokenDirty  bool                     // have we changed the AccessToken?
 	Assertion   map[string]SAMLAssertion `json:"assertion"`
 	AccessToken AccessTokenResponse      `json:"accesstoken"`
 }
@@ -45,54 +64,43 @@ type SAMLAssertion struct {
 	Roles        []string `json:"Roles"`
 }
 
+// Loads the given cache file or our default cache if filename is empty
 func LoadOneLoginCache(filename string) *OneLoginCache {
-	fname := filename
-	_, err := os.Stat(fname)
+	return NewOneLoginCache(NewFileCacheBackend(filename, nil))
+}
+
+// Loads our cache from the given backend
+func NewOneLoginCache(backend CacheBackend) *OneLoginCache {
+	c, err := backend.Read()
 	if err != nil {
-		fname = saml_cache()
+		log.WithError(err).Error("Unable to read cache")
 	}
-	f, err := os.Open(fname)
-	if err != nil {
-		c := OneLoginCache{
-			filename:    fname,
-			Assertion:   map[string]SAMLAssertion{},
-			AccessToken: AccessTokenResponse{},
-		}
-		return &c
+	if c == nil {
+		c = &OneLoginCache{}
 	}
-
-	defer f.Close()
-
-	d := json.NewDecoder(f)
-	c := OneLoginCache{}
-	err = d.Decode(&c)
-	if err != nil {
-		log.Errorf("Corrupted cache file %s: %s", fname, err.Error())
-		c = OneLoginCache{
-			filename:    fname,
-			Assertion:   map[string]SAMLAssertion{},
-			AccessToken: AccessTokenResponse{},
-		}
-		return &c
-	}
-	if c.filename == "" {
-		c.filename = fname
-	}
-	return &c
+	if c.Assertion == nil {
+		c.Assertion = map[string]SAMLAssertion{}
+	}
+	c.backend = backend
+	c.dirty = map[string]bool{}
+	return c
 }
 
+// Saves our changes via our backend
 func (olc *OneLoginCache) Save() error {
-	f, err := os.OpenFile(olc.filename, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
-	if err != nil {
-		return fmt.Errorf("Unable to open %s: %s", olc.filename, err.Error())
+	if olc.backend == nil {
+		olc.backend = NewFileCacheBackend("", nil)
 	}
-	defer f.Close()
-
-	e := json.NewEncoder(f)
-	err = e.Encode(olc)
+	ids := []string{}
+	for id := range olc.dirty {
+		ids = append(ids, id)
+	}
+	err := olc.backend.Write(olc, ids, olc.tokenDirty)
 	if err != nil {
-		return fmt.Errorf("Error writing %s: %s", olc.filename, err.Error())
+		return err
 	}
+	olc.dirty = map[string]bool{}
+	olc.tokenDirty = false
 	return nil
 }
 
@@ -117,11 +125,16 @@ func (olc *OneLoginCache) SaveAssertion(app_id uint32, assertion string) error {
 		olc.Assertion = map[string]SAMLAssertion{}
 	}
 	olc.Assertion[id] = x
+	if olc.dirty == nil {
+		olc.dirty = map[string]bool{}
+	}
+	olc.dirty[id] = true
 	return olc.Save()
 }
 
 func (olc *OneLoginCache) SaveAccessToken(token *AccessTokenResponse) error {
 	olc.AccessToken = *token
+	olc.tokenDirty = true
 	return olc.Save()
 }
 
@@ -129,9 +142,10 @@ func (olc *OneLoginCache) GetAccessToken() (string, error) {
 	if olc.AccessToken.AccessToken == "" {
 		return "", fmt.Errorf("No current OAuth2 AccessToken")
 	}
-	now := time.Now()
-	expires := time.Unix(olc.AccessToken.ExpiresIn, 0)
-	if expires.Before(now) {
+	expired, err := olc.AccessToken.IsExpired()
+	if err != nil {
+		return "", err
+	} else if expired {
 		return "", fmt.Errorf("OAuth2 AccessToken has expired")
 	}
 	return olc.AccessToken.AccessToken, nil
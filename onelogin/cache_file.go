@@ -0,0 +1,262 @@
+package onelogin
+
+/*
+ * OneLogin AWS Role
+ * Copyright (c) 2020-2021 Aaron Turner  <aturner at synfin dot net>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+/*
+ * Stores our cache as a JSON file, optionally encrypted with AES-GCM
+ */
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/synfinatic/onelogin-aws-role/utils"
+)
+
+// Length of the key for encrypted cache files
+const CACHE_KEY_LEN = 32
+
+// The cache file should be encrypted, but contains plain JSON
+var ErrCacheNotEncrypted = errors.New("cache file is not encrypted")
+
+type FileCacheBackend struct {
+	filename string
+	key      []byte // if set, encrypt the file with this AES-256 key
+}
+
+/*
+ * Returns a backend for the given cache file or our default cache if filename
+ * is empty.  If key is not nil, the file is encrypted.
+ */
+func NewFileCacheBackend(filename string, key []byte) *FileCacheBackend {
+	if filename == "" {
+		filename = saml_cache()
+	}
+	return &FileCacheBackend{
+		filename: filename,
+		key:      key,
+	}
+}
+
+// Returns a new random key for NewFileCacheBackend()
+func NewCacheKey() ([]byte, error) {
+	key := make([]byte, CACHE_KEY_LEN)
+	_, err := io.ReadFull(rand.Reader, key)
+	return key, err
+}
+
+func (fcb *FileCacheBackend) lockFile() string {
+	return fcb.filename + ".lock"
+}
+
+func (fcb *FileCacheBackend) Read() (*OneLoginCache, error) {
+	if err := utils.EnsureDir(fcb.filename); err != nil {
+		log.WithError(err).Warn("Unable to create cache directory")
+	}
+	lock, err := utils.LockFile(fcb.lockFile())
+	if err != nil {
+		log.WithError(err).Warn("Unable to lock cache file")
+	} else {
+		defer lock.Unlock()
+	}
+	return fcb.read()
+}
+
+// Reads the cache file.  Always returns a valid cache, even on error
+func (fcb *FileCacheBackend) read() (*OneLoginCache, error) {
+	c := OneLoginCache{
+		Assertion: map[string]SAMLAssertion{},
+	}
+	buf, err := ioutil.ReadFile(fcb.filename)
+	if os.IsNotExist(err) {
+		return &c, nil
+	} else if err != nil {
+		return &c, fmt.Errorf("Unable to read %s: %s", fcb.filename, err.Error())
+	}
+
+	if fcb.key != nil {
+		// anyone could have written a plain JSON file, so we don't trust it
+		if isPlaintext(buf) {
+			return &c, fmt.Errorf("Refusing to use %s: %w", fcb.filename, ErrCacheNotEncrypted)
+		}
+		buf, err = fcb.decrypt(buf)
+		if err != nil {
+			return &c, fmt.Errorf("Unable to decrypt %s: %s", fcb.filename, err.Error())
+		}
+	}
+
+	err = json.Unmarshal(buf, &c)
+	if err != nil {
+		return &c, fmt.Errorf("Corrupted cache file %s: %s", fcb.filename, err.Error())
+	}
+	if c.Assertion == nil {
+		c.Assertion = map[string]SAMLAssertion{}
+	}
+	return &c, nil
+}
+
+/*
+ * Encrypts an existing plain JSON cache file in place.  Only call this when
+ * first switching to an encrypted cache, since we trust the file's contents.
+ */
+func (fcb *FileCacheBackend) Migrate() error {
+	if fcb.key == nil {
+		return fmt.Errorf("Unable to encrypt %s without a key", fcb.filename)
+	}
+	lock, err := utils.LockFile(fcb.lockFile())
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	buf, err := ioutil.ReadFile(fcb.filename)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("Unable to read %s: %s", fcb.filename, err.Error())
+	}
+	if !isPlaintext(buf) {
+		return nil // already encrypted
+	}
+
+	c := OneLoginCache{}
+	if err = json.Unmarshal(buf, &c); err != nil {
+		return fmt.Errorf("Corrupted cache file %s: %s", fcb.filename, err.Error())
+	}
+	buf, err = json.Marshal(c)
+	if err == nil {
+		buf, err = fcb.encrypt(buf)
+	}
+	if err == nil {
+		err = utils.WriteFileAtomic(fcb.filename, buf, 0600)
+	}
+	if err != nil {
+		return fmt.Errorf("Error writing %s: %s", fcb.filename, err.Error())
+	}
+	log.Infof("Encrypted cache file %s", fcb.filename)
+	return nil
+}
+
+// Removes the cache file, such as after switching to another cache backend
+func (fcb *FileCacheBackend) Remove() error {
+	if _, err := os.Stat(fcb.filename); os.IsNotExist(err) {
+		return nil
+	}
+	lock, err := utils.LockFile(fcb.lockFile())
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	err = os.Remove(fcb.filename)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	log.Infof("Removed old cache file %s", fcb.filename)
+	return nil
+}
+
+/*
+ * Another copy of onelogin-aws-role may have updated the file since we
+ * loaded it, so we re-read it and only replace the values which changed.
+ */
+func (fcb *FileCacheBackend) Write(olc *OneLoginCache, assertions []string, token bool) error {
+	if err := utils.EnsureDir(fcb.filename); err != nil {
+		return fmt.Errorf("Unable to create cache directory: %s", err.Error())
+	}
+	lock, err := utils.LockFile(fcb.lockFile())
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	c, err := fcb.read()
+	if err != nil {
+		log.WithError(err).Warnf("Overwriting cache file %s", fcb.filename)
+	}
+	for _, id := range assertions {
+		c.Assertion[id] = olc.Assertion[id]
+	}
+	if token {
+		c.AccessToken = olc.AccessToken
+	}
+
+	buf, err := json.Marshal(c)
+	if err == nil && fcb.key != nil {
+		buf, err = fcb.encrypt(buf)
+	}
+	if err != nil {
+		return fmt.Errorf("Error writing %s: %s", fcb.filename, err.Error())
+	}
+	err = utils.WriteFileAtomic(fcb.filename, buf, 0600)
+	if err != nil {
+		return fmt.Errorf("Error writing %s: %s", fcb.filename, err.Error())
+	}
+
+	olc.Assertion = c.Assertion
+	olc.AccessToken = c.AccessToken
+	return nil
+}
+
+// The nonce of an encrypted file may start with a '{', but it won't be valid JSON
+func isPlaintext(buf []byte) bool {
+	return bytes.HasPrefix(buf, []byte("{")) && json.Valid(buf)
+}
+
+// Returns the nonce + ciphertext
+func (fcb *FileCacheBackend) encrypt(plaintext []byte) ([]byte, error) {
+	gcm, err := fcb.cipher()
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func (fcb *FileCacheBackend) decrypt(data []byte) ([]byte, error) {
+	gcm, err := fcb.cipher()
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, fmt.Errorf("file is too short")
+	}
+	nonce := data[:gcm.NonceSize()]
+	return gcm.Open(nil, nonce, data[gcm.NonceSize():], nil)
+}
+
+func (fcb *FileCacheBackend) cipher() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(fcb.key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
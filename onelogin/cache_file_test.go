@@ -0,0 +1,271 @@
+package onelogin
+
+/*
+ * OneLogin AWS Role
+ * Copyright (c) 2020-2021 Aaron Turner  <aturner at synfin dot net>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testCacheFile(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "onelogin-aws-role")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return filepath.Join(dir, "cache.json")
+}
+
+func testCacheKey(t *testing.T) []byte {
+	key, err := NewCacheKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func testAssertion(name string) SAMLAssertion {
+	return SAMLAssertion{
+		NotOnOrAfter: 2000000000,
+		Assertion:    "<Response>" + name + "</Response>",
+		Roles:        []string{"arn:aws:iam::123456789012:role/" + name},
+	}
+}
+
+func TestFileCacheMerge(t *testing.T) {
+	filename := testCacheFile(t)
+	for _, key := range [][]byte{nil, testCacheKey(t)} {
+		os.Remove(filename)
+		// two copies of onelogin-aws-role load the same cache
+		first := NewOneLoginCache(NewFileCacheBackend(filename, key))
+		second := NewOneLoginCache(NewFileCacheBackend(filename, key))
+
+		first.Assertion["1"] = testAssertion("first")
+		if err := first.backend.Write(first, []string{"1"}, false); err != nil {
+			t.Fatalf("Write(): %s", err)
+		}
+		second.Assertion["2"] = testAssertion("second")
+		second.AccessToken = AccessTokenResponse{AccessToken: "token"}
+		if err := second.backend.Write(second, []string{"2"}, true); err != nil {
+			t.Fatalf("Write(): %s", err)
+		}
+
+		c, err := NewFileCacheBackend(filename, key).Read()
+		if err != nil {
+			t.Fatalf("Read(): %s", err)
+		}
+		if c.Assertion["1"].Assertion != "<Response>first</Response>" ||
+			c.Assertion["2"].Assertion != "<Response>second</Response>" {
+			t.Errorf("assertions were not merged: %+v", c.Assertion)
+		}
+		if c.AccessToken.AccessToken != "token" {
+			t.Errorf("AccessToken = %s", c.AccessToken.AccessToken)
+		}
+		// the writer also sees the other's changes
+		if _, ok := second.Assertion["1"]; !ok {
+			t.Errorf("Write() did not update our copy of the cache")
+		}
+	}
+}
+
+func TestFileCacheWriteOnlyChanged(t *testing.T) {
+	filename := testCacheFile(t)
+	fcb := NewFileCacheBackend(filename, nil)
+	c := &OneLoginCache{Assertion: map[string]SAMLAssertion{"1": testAssertion("old")}}
+	if err := fcb.Write(c, []string{"1"}, false); err != nil {
+		t.Fatalf("Write(): %s", err)
+	}
+
+	// a stale copy which didn't change app 1 mustn't overwrite it
+	stale := &OneLoginCache{Assertion: map[string]SAMLAssertion{
+		"1": testAssertion("stale"),
+		"2": testAssertion("new"),
+	}}
+	other := NewOneLoginCache(fcb)
+	other.Assertion["1"] = testAssertion("newer")
+	if err := fcb.Write(other, []string{"1"}, false); err != nil {
+		t.Fatalf("Write(): %s", err)
+	}
+	if err := fcb.Write(stale, []string{"2"}, false); err != nil {
+		t.Fatalf("Write(): %s", err)
+	}
+	got, _ := fcb.Read()
+	if got.Assertion["1"].Assertion != "<Response>newer</Response>" {
+		t.Errorf("assertion 1 = %s", got.Assertion["1"].Assertion)
+	}
+}
+
+func TestFileCacheAtomicWrite(t *testing.T) {
+	filename := testCacheFile(t)
+	fcb := NewFileCacheBackend(filename, nil)
+	c := &OneLoginCache{Assertion: map[string]SAMLAssertion{"1": testAssertion("old")}}
+	if err := fcb.Write(c, []string{"1"}, false); err != nil {
+		t.Fatalf("Write(): %s", err)
+	}
+	old, _ := ioutil.ReadFile(filename)
+
+	// a hard link keeps the old contents if the file is replaced, not rewritten
+	link := filename + ".link"
+	if err := os.Link(filename, link); err != nil {
+		t.Skipf("Unable to create hard link: %s", err)
+	}
+	c.Assertion["1"] = testAssertion("new")
+	if err := fcb.Write(c, []string{"1"}, false); err != nil {
+		t.Fatalf("Write(): %s", err)
+	}
+	linked, _ := ioutil.ReadFile(link)
+	if !bytes.Equal(old, linked) {
+		t.Errorf("Write() modified the cache file in place")
+	}
+
+	info, err := os.Stat(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("cache file mode = %o", info.Mode().Perm())
+	}
+	files, _ := filepath.Glob(filepath.Join(filepath.Dir(filename), "*.tmp*"))
+	if len(files) > 0 {
+		t.Errorf("Write() left temporary files: %v", files)
+	}
+}
+
+func TestFileCacheRemove(t *testing.T) {
+	filename := testCacheFile(t)
+	fcb := NewFileCacheBackend(filename, nil)
+	if err := fcb.Remove(); err != nil {
+		t.Errorf("Remove() of a missing file: %s", err)
+	}
+	c := &OneLoginCache{Assertion: map[string]SAMLAssertion{"1": testAssertion("gone")}}
+	if err := fcb.Write(c, []string{"1"}, false); err != nil {
+		t.Fatalf("Write(): %s", err)
+	}
+	if err := fcb.Remove(); err != nil {
+		t.Fatalf("Remove(): %s", err)
+	}
+	if _, err := os.Stat(filename); !os.IsNotExist(err) {
+		t.Errorf("Remove() left %s", filename)
+	}
+}
+func TestFileCacheEncryption(t *testing.T) {
+	filename := testCacheFile(t)
+	key := testCacheKey(t)
+	fcb := NewFileCacheBackend(filename, key)
+	c := &OneLoginCache{
+		Assertion:   map[string]SAMLAssertion{"1": testAssertion("secret")},
+		AccessToken: AccessTokenResponse{AccessToken: "secret-token"},
+	}
+	if err := fcb.Write(c, []string{"1"}, true); err != nil {
+		t.Fatalf("Write(): %s", err)
+	}
+
+	buf, _ := ioutil.ReadFile(filename)
+	if bytes.Contains(buf, []byte("secret")) || isPlaintext(buf) {
+		t.Errorf("cache file is not encrypted: %s", buf)
+	}
+
+	got, err := NewFileCacheBackend(filename, key).Read()
+	if err != nil {
+		t.Fatalf("Read(): %s", err)
+	}
+	if got.Assertion["1"].Assertion != "<Response>secret</Response>" || got.AccessToken.AccessToken != "secret-token" {
+		t.Errorf("Read() = %+v", got)
+	}
+}
+
+func TestFileCacheWrongKey(t *testing.T) {
+	filename := testCacheFile(t)
+	c := &OneLoginCache{Assertion: map[string]SAMLAssertion{"1": testAssertion("secret")}}
+	if err := NewFileCacheBackend(filename, testCacheKey(t)).Write(c, []string{"1"}, false); err != nil {
+		t.Fatalf("Write(): %s", err)
+	}
+
+	got, err := NewFileCacheBackend(filename, testCacheKey(t)).Read()
+	if err == nil {
+		t.Errorf("Read() with the wrong key succeeded")
+	}
+	if got == nil || len(got.Assertion) != 0 {
+		t.Errorf("Read() with the wrong key = %+v, want an empty cache", got)
+	}
+
+	// truncated files are also rejected
+	if err = ioutil.WriteFile(filename, []byte("short"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = NewFileCacheBackend(filename, testCacheKey(t)).Read(); err == nil {
+		t.Errorf("Read() of a truncated file succeeded")
+	}
+}
+
+func TestFileCachePlaintext(t *testing.T) {
+	filename := testCacheFile(t)
+	c := &OneLoginCache{Assertion: map[string]SAMLAssertion{"1": testAssertion("plain")}}
+	if err := NewFileCacheBackend(filename, nil).Write(c, []string{"1"}, false); err != nil {
+		t.Fatalf("Write(): %s", err)
+	}
+
+	key := testCacheKey(t)
+	fcb := NewFileCacheBackend(filename, key)
+	got, err := fcb.Read()
+	if !errors.Is(err, ErrCacheNotEncrypted) {
+		t.Errorf("Read() of a plaintext cache error = %v, want %v", err, ErrCacheNotEncrypted)
+	}
+	if len(got.Assertion) != 0 {
+		t.Errorf("Read() trusted a plaintext cache: %+v", got)
+	}
+
+	// until we explicitly migrate it
+	if err = fcb.Migrate(); err != nil {
+		t.Fatalf("Migrate(): %s", err)
+	}
+	got, err = fcb.Read()
+	if err != nil {
+		t.Fatalf("Read() after Migrate(): %s", err)
+	}
+	if got.Assertion["1"].Assertion != "<Response>plain</Response>" {
+		t.Errorf("Read() after Migrate() = %+v", got)
+	}
+
+	// a plaintext file written afterwards is refused again
+	if err = ioutil.WriteFile(filename, []byte(`{"assertion":{}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = fcb.Read(); !errors.Is(err, ErrCacheNotEncrypted) {
+		t.Errorf("Read() of a new plaintext cache error = %v, want %v", err, ErrCacheNotEncrypted)
+	}
+}
+
+func TestIsPlaintext(t *testing.T) {
+	tests := map[string]bool{
+		`{"assertion":{}}`: true,
+		"{\x8f\x01binary":  false,
+		"":                 false,
+		"\x00\x01":         false,
+	}
+	for buf, want := range tests {
+		if got := isPlaintext([]byte(buf)); got != want {
+			t.Errorf("isPlaintext(%q) = %v, want %v", buf, got, want)
+		}
+	}
+}
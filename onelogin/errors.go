@@ -0,0 +1,209 @@
+package onelogin
+
+/*
+ * OneLogin AWS Role
+ * Copyright (c) 2020-2021 Aaron Turner  <aturner at synfin dot net>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+/*
+ * OneLogin API failures are returned as a *OneLoginError which wraps one of
+ * the Err* values below so callers can use errors.Is() to decide what to do.
+ */
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	resty "github.com/go-resty/resty/v2"
+)
+
+var (
+	ErrInvalidCredentials = errors.New("Invalid OneLogin username or password")
+	ErrAccountLocked      = errors.New("OneLogin account is locked")
+	ErrMFAFailed          = errors.New("MFA verification failed")
+	ErrAppNotAssigned     = errors.New("OneLogin app is not assigned to you")
+	ErrRateLimited        = errors.New("OneLogin API rate limit exceeded")
+	ErrPasswordExpired    = errors.New("OneLogin password has expired")
+	ErrMFAEnrollment      = errors.New("OneLogin requires you to enroll an MFA device")
+	ErrNetwork            = errors.New("Unable to communicate with OneLogin")
+	ErrPushDenied         = errors.New("OneLogin Protect push was denied")
+	ErrPushTimeout        = errors.New("OneLogin Protect push timed out")
+	ErrLoginExpired       = errors.New("OneLogin login session has expired")
+	ErrOAuthFailed        = errors.New("OneLogin rejected our OAuth2 credentials")
+)
+
+// What the user can do about each kind of error
+var errorHints = map[error]string{
+	ErrInvalidCredentials: "check your username and password",
+	ErrAccountLocked:      "wait for the lock to expire or contact your OneLogin administrator",
+	ErrMFAFailed:          "check your MFA device and try again",
+	ErrAppNotAssigned:     "check the app_id in your config file or ask your OneLogin administrator to assign you the app",
+	ErrRateLimited:        "wait a few minutes and try again",
+	ErrPasswordExpired:    "change your password via the OneLogin portal",
+	ErrMFAEnrollment:      "login to the OneLogin portal and enroll an MFA device",
+	ErrNetwork:            "check your network connection",
+	ErrLoginExpired:       "try again",
+	ErrOAuthFailed:        "check your OneLogin client_id and client_secret",
+}
+
+type OneLoginError struct {
+	Kind       error  // one of the Err* values or nil if unknown
+	Action     string // what we were trying to do
+	StatusCode int    // HTTP status code, 0 for network errors
+	Message    string // from OneLogin or the network error
+}
+
+func (e *OneLoginError) Error() string {
+	msg := e.Message
+	if e.Kind != nil {
+		msg = fmt.Sprintf("%s: %s", e.Kind.Error(), e.Message)
+	}
+	if e.StatusCode != 0 {
+		msg = fmt.Sprintf("%s [%d]", msg, e.StatusCode)
+	}
+	if hint, ok := errorHints[e.Kind]; ok {
+		msg = fmt.Sprintf("%s (%s)", msg, hint)
+	}
+	return fmt.Sprintf("Unable to %s: %s", e.Action, msg)
+}
+
+func (e *OneLoginError) Unwrap() error {
+	return e.Kind
+}
+
+// The different formats OneLogin uses for error responses
+type errorResponse struct {
+	Status     *OneLoginStatus `json:"status"`
+	StatusCode int             `json:"statusCode"`
+	Name       string          `json:"name"`
+	Message    string          `json:"message"`
+	Error      string          `json:"error"`
+	ErrorDesc  string          `json:"error_description"`
+}
+
+// Wraps errors returned by resty when we can't talk to OneLogin
+func networkError(action string, err error) error {
+	return &OneLoginError{
+		Kind:    ErrNetwork,
+		Action:  action,
+		Message: err.Error(),
+	}
+}
+
+/*
+ * Converts a failed OneLogin API response into a *OneLoginError.
+ * unauthorized is the Kind to use for a 401 which doesn't match anything
+ * more specific, since that depends on which API we called.
+ */
+func responseError(action string, resp *resty.Response, unauthorized error) error {
+	return newResponseError(action, resp.StatusCode(), resp.Header(), resp.Body(), unauthorized)
+}
+
+func newResponseError(action string, code int, header http.Header, body []byte, unauthorized error) *OneLoginError {
+	e := OneLoginError{
+		Action:     action,
+		StatusCode: code,
+		Message:    strings.TrimSpace(string(body)),
+	}
+
+	er := errorResponse{}
+	if json.Unmarshal(body, &er) == nil {
+		switch {
+		case er.Status != nil && er.Status.Message != "":
+			e.Message = er.Status.Message
+		case er.Message != "":
+			e.Message = er.Message
+		case er.ErrorDesc != "":
+			e.Message = er.ErrorDesc
+		case er.Error != "":
+			e.Message = er.Error
+		}
+	}
+
+	e.Kind = errorKind(code, header, er, e.Message, unauthorized)
+	return &e
+}
+
+// Messages which tell us which kind of error we have
+var (
+	lockedMessage     = regexp.MustCompile(`\b(account|user) (is )?locked\b|\blocked out\b`)
+	notAssigned       = regexp.MustCompile(`\bnot assigned\b|\b(app|application) not found\b`)
+	mfaFailedMessage  = regexp.MustCompile(`\b(invalid|incorrect|wrong|failed)\b.*\b(otp|mfa|factor)\b|\b(otp|mfa)\b.*\b(invalid|incorrect|wrong|failed)\b`)
+	invalidUserCreds  = regexp.MustCompile(`\binvalid user credentials\b|\b(username|password) (is )?(invalid|incorrect)\b`)
+	oauthErrorCodes   = map[string]bool{"invalid_token": true, "invalid_client": true, "unauthorized_client": true}
+	oauthFailureTexts = map[string]bool{"authentication failure": true, "unauthorized": true}
+)
+
+/*
+ * Classifies an error response.  The status code and the structured fields
+ * of the response are more reliable than the message, so we check them
+ * first.  A 401 because OneLogin rejected our OAuth2 token is never an
+ * invalid username or password.
+ */
+func errorKind(code int, header http.Header, er errorResponse, message string, unauthorized error) error {
+	msg := strings.ToLower(strings.TrimSpace(message))
+	switch {
+	case code == http.StatusTooManyRequests:
+		return ErrRateLimited
+	case oauthRejected(code, header, er, msg):
+		return ErrOAuthFailed
+	case lockedMessage.MatchString(msg):
+		return ErrAccountLocked
+	case passwordExpired(msg):
+		return ErrPasswordExpired
+	case mfaEnrollment(msg):
+		return ErrMFAEnrollment
+	case code == http.StatusUnauthorized && invalidUserCreds.MatchString(msg):
+		return ErrInvalidCredentials
+	case notAssigned.MatchString(msg):
+		return ErrAppNotAssigned
+	case mfaFailedMessage.MatchString(msg):
+		return ErrMFAFailed
+	case strings.Contains(msg, "rate limit"):
+		return ErrRateLimited
+	case code == http.StatusUnauthorized:
+		return unauthorized
+	}
+	return nil
+}
+
+// Returns true if OneLogin rejected our OAuth2 access token or client credentials
+func oauthRejected(code int, header http.Header, er errorResponse, msg string) bool {
+	if code != http.StatusUnauthorized {
+		return false
+	}
+	// RFC 6750
+	if strings.Contains(header.Get("WWW-Authenticate"), `error="invalid_token"`) {
+		return true
+	}
+	return oauthErrorCodes[er.Error] || oauthFailureTexts[msg]
+}
+
+func passwordExpired(msg string) bool {
+	msg = strings.ToLower(msg)
+	return strings.Contains(msg, "password") &&
+		(strings.Contains(msg, "expired") || strings.Contains(msg, "must be changed"))
+}
+
+func mfaEnrollment(msg string) bool {
+	msg = strings.ToLower(msg)
+	return (strings.Contains(msg, "mfa") || strings.Contains(msg, "factor")) &&
+		(strings.Contains(msg, "registration") || strings.Contains(msg, "enroll") || strings.Contains(msg, "register"))
+}
@@ -0,0 +1,101 @@
+package onelogin
+
+/*
+ * OneLogin AWS Role
+ * Copyright (c) 2020-2021 Aaron Turner  <aturner at synfin dot net>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestErrorKind(t *testing.T) {
+	tests := []struct {
+		name         string
+		code         int
+		header       http.Header
+		body         string
+		unauthorized error
+		kind         error
+	}{
+		{"rate limited", 429, nil, `{"statusCode":429,"message":"Too Many Requests"}`, nil, ErrRateLimited},
+		{"rate limit message", 400, nil, `{"message":"API rate limit exceeded"}`, nil, ErrRateLimited},
+		{"invalid user credentials", 401, nil,
+			`{"status":{"error":true,"code":401,"type":"Unauthorized","message":"Authentication Failed: Invalid user credentials"}}`,
+			ErrInvalidCredentials, ErrInvalidCredentials},
+		{"access token rejected", 401, nil,
+			`{"status":{"error":true,"code":401,"type":"Unauthorized","message":"Authentication Failure"}}`,
+			ErrInvalidCredentials, ErrOAuthFailed},
+		{"access token rejected v2", 401, nil, `{"statusCode":401,"name":"Unauthorized","message":"Authentication Failure"}`,
+			ErrInvalidCredentials, ErrOAuthFailed},
+		{"invalid_token header", 401, http.Header{"Www-Authenticate": {`Bearer error="invalid_token"`}}, ``,
+			ErrInvalidCredentials, ErrOAuthFailed},
+		{"invalid_client", 401, nil, `{"error":"invalid_client","error_description":"Client authentication failed"}`,
+			ErrOAuthFailed, ErrOAuthFailed},
+		{"authentication failure not 401", 400, nil, `{"message":"Authentication Failure"}`, nil, nil},
+		{"account locked", 401, nil, `{"status":{"code":401,"message":"Account is locked"}}`, ErrInvalidCredentials, ErrAccountLocked},
+		{"user locked out", 401, nil, `{"message":"User locked out due to failed attempts"}`, ErrInvalidCredentials, ErrAccountLocked},
+		{"unlocked is not locked", 400, nil, `{"message":"User was unlocked"}`, nil, nil},
+		{"password expired", 401, nil, `{"message":"Password is expired"}`, ErrInvalidCredentials, ErrPasswordExpired},
+		{"password must be changed", 401, nil, `{"message":"Password must be changed"}`, ErrInvalidCredentials, ErrPasswordExpired},
+		{"mfa enrollment", 400, nil, `{"message":"MFA registration required"}`, nil, ErrMFAEnrollment},
+		{"app not assigned", 400, nil, `{"message":"App is not assigned to user"}`, nil, ErrAppNotAssigned},
+		{"app not found", 404, nil, `{"message":"Application not found"}`, nil, ErrAppNotAssigned},
+		{"invalid otp", 400, nil, `{"message":"Invalid OTP token"}`, nil, ErrMFAFailed},
+		{"failed factor", 401, nil, `{"message":"Failed authentication with this factor"}`, ErrMFAFailed, ErrMFAFailed},
+		{"factor is not an mfa error", 400, nil, `{"message":"Risk factor score unavailable"}`, nil, nil},
+		{"otp substring", 400, nil, `{"message":"Unknown option: otpauth_uri"}`, nil, nil},
+		{"unknown 401", 401, nil, `{"message":"Denied"}`, ErrLoginExpired, ErrLoginExpired},
+		{"unknown 401 without a kind", 401, nil, `not json`, nil, nil},
+		{"server error", 500, nil, `{"message":"Internal Server Error"}`, ErrInvalidCredentials, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := tt.header
+			if header == nil {
+				header = http.Header{}
+			}
+			e := newResponseError("test", tt.code, header, []byte(tt.body), tt.unauthorized)
+			if e.Kind != tt.kind {
+				t.Errorf("Kind = %v, want %v", e.Kind, tt.kind)
+			}
+			if tt.kind != nil && !errors.Is(e, tt.kind) {
+				t.Errorf("errors.Is(%v, %v) = false", e, tt.kind)
+			}
+		})
+	}
+}
+
+func TestResponseErrorMessage(t *testing.T) {
+	tests := []struct {
+		body    string
+		message string
+	}{
+		{`{"status":{"error":true,"code":401,"type":"Unauthorized","message":"Authentication Failure"}}`, "Authentication Failure"},
+		{`{"statusCode":404,"name":"NotFound","message":"Not found"}`, "Not found"},
+		{`{"error":"invalid_client","error_description":"Client authentication failed"}`, "Client authentication failed"},
+		{`{"error":"invalid_request"}`, "invalid_request"},
+		{"Bad Gateway\n", "Bad Gateway"},
+	}
+	for _, tt := range tests {
+		e := newResponseError("test", 502, http.Header{}, []byte(tt.body), nil)
+		if e.Message != tt.message {
+			t.Errorf("Message = %q, want %q", e.Message, tt.message)
+		}
+	}
+}
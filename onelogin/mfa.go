@@ -22,11 +22,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"reflect"
-	"strconv"
 
-	"github.com/Songmu/prompter"
 	resty "github.com/go-resty/resty/v2"
-	log "github.com/sirupsen/logrus"
 	"github.com/synfinatic/onelogin-aws-role/utils"
 )
 
@@ -58,11 +55,11 @@ func (mfa *MFA) SetParam(key string, value string) {
 }
 
 // returns json encoded result
-func (mfa *MFA) SubmitMFA(device_id int32, mfa_code int32) (string, error) {
+func (mfa *MFA) SubmitMFA(device_id int32, otp string) (string, error) {
 	data := map[string]string{
 		"state_token": mfa.StateToken,
 		"device_id":   fmt.Sprintf("%d", device_id),
-		"otp_token":   fmt.Sprintf("%d", mfa_code),
+		"otp_token":   otp,
 	}
 	for k, v := range mfa.params {
 		data[k] = v
@@ -72,9 +69,35 @@ func (mfa *MFA) SubmitMFA(device_id int32, mfa_code int32) (string, error) {
 		SetBody(body).
 		Post(mfa.CallbackUrl)
 	if err != nil {
-		return "", fmt.Errorf("Unable to submit MFA token code: %s", err.Error())
+		return "", networkError("submit MFA token code", err)
 	} else if resp.IsError() {
-		return "", fmt.Errorf("Unable to submit MFA token code: %s [%d]", resp.String(), resp.StatusCode())
+		return "", responseError("submit MFA token code", resp, ErrMFAFailed)
+	}
+	return resp.String(), nil
+}
+
+/*
+ * SMS, Email and Voice factors only send the user a code after we ask
+ * OneLogin to do so via a verify_factor call without an otp_token.
+ * returns json encoded result
+ */
+func (mfa *MFA) TriggerMFA(device_id int32) (string, error) {
+	data := map[string]string{
+		"state_token":   mfa.StateToken,
+		"device_id":     fmt.Sprintf("%d", device_id),
+		"do_not_notify": "false",
+	}
+	for k, v := range mfa.params {
+		data[k] = v
+	}
+	body, _ := json.Marshal(data)
+	resp, err := mfa.client.R().
+		SetBody(body).
+		Post(mfa.CallbackUrl)
+	if err != nil {
+		return "", networkError("send MFA token code", err)
+	} else if resp.IsError() {
+		return "", responseError("send MFA token code", resp, ErrMFAFailed)
 	}
 	return resp.String(), nil
 }
@@ -111,9 +134,20 @@ func (mfa *MFA) OneLoginProtectPush(notify bool) (string, error) {
 		SetBody(body).
 		Post(mfa.CallbackUrl)
 	if err != nil {
-		return "", fmt.Errorf("Unable to use OneLogin Protect Push: %s", err.Error())
+		return "", networkError("use OneLogin Protect Push", err)
+	} else if resp.IsError() && !notify {
+		/*
+		 * OneLogin rejects our poll once the user has denied the push, but
+		 * also when our state_token or access token has expired, so only the
+		 * message tells us if the user denied it
+		 */
+		err := responseError("use OneLogin Protect Push", resp, ErrLoginExpired)
+		if oe, ok := err.(*OneLoginError); ok && pushDenied(oe.Message) {
+			oe.Kind = ErrPushDenied
+		}
+		return "", err
 	} else if resp.IsError() {
-		return "", fmt.Errorf("Unable to use OneLogin Protect Push: %s [%d]", resp.String(), resp.StatusCode())
+		return "", responseError("use OneLogin Protect Push", resp, ErrMFAFailed)
 	}
 	return resp.String(), nil
 }
@@ -131,42 +165,6 @@ func GenerateMfaSelect(devices []MfaDevice) *[]MfaSelect {
 	return &mfaDevices
 }
 
-func SelectMfaDevice(mfaDevices []MfaDevice) int32 {
-	// If there is only one, don't bother prompting user
-	if len(mfaDevices) == 1 {
-		return mfaDevices[0].DeviceId
-	}
-
-	m := GenerateMfaSelect(mfaDevices)
-	mfaSelect := *m
-	fields := []string{
-		"Select",
-		"DeviceType",
-		"DeviceId",
-	}
-
-	ts := []utils.TableStruct{}
-	for _, mfa := range mfaSelect {
-		ts = append(ts, mfa)
-	}
-	utils.GenerateTable(ts, fields)
-	fmt.Printf("\n")
-
-	var mfaid int32 = 0
-	for mfaid == 0 {
-		sel := prompter.Prompt("Select MFA Device", "")
-		x, err := strconv.ParseInt(sel, 10, 32)
-		if err != nil || x > int64(len(mfaDevices)) || x < 1 {
-			log.Errorf("Invalid MFA selector: please choose 1-%d", len(mfaDevices))
-			mfaid = 0
-			continue
-		}
-		mfa := mfaSelect[x-1]
-		mfaid = mfa.DeviceId
-	}
-	return mfaid
-}
-
 func (mfa MfaDevice) GetHeader(fieldName string) (string, error) {
 	v := reflect.ValueOf(mfa)
 	return utils.GetHeaderTag(v, fieldName)
@@ -21,6 +21,7 @@ package onelogin
 import (
 	"encoding/json"
 	"fmt"
+	"net/http"
 	"time"
 
 	resty "github.com/go-resty/resty/v2"
@@ -28,13 +29,17 @@ import (
 )
 
 type OneLogin struct {
-	Client      *resty.Client
-	Cache       *OneLoginCache
-	Url         string // api url for onelogin
-	AccessToken string // generated via OAuth2.  Required for all other API calls
-
+	Client       *resty.Client
+	Cache        *OneLoginCache
+	Url          string // api url for onelogin
+	AccessToken  string // generated via OAuth2.  Required for all other API calls
+	clientId     string
+	clientSecret string
 }
 
+// Get a new OAuth2 token if ours expires within this window
+const ACCESS_TOKEN_WINDOW = time.Minute
+
 type AccessTokenResponse struct {
 	AccessToken string `json:"access_token"`
 	CreatedAt   string `json:"created_at"`
@@ -61,14 +66,20 @@ type OneLoginStatus struct {
 }
 
 /*
- * Returns a new OneLogin struct with our AccessToken configured
+ * Returns a new OneLogin struct with our AccessToken configured.  If cache
+ * is nil, our default cache file is used.  If client is nil, the default
+ * http.Client is used.
  *
  * OneLogin OAuth2 tokens are good for 10hrs
  */
-func NewOneLogin(clientid string, client_secret string, region string) (*OneLogin, error) {
-	cache := LoadOneLoginCache("")
+func NewOneLogin(clientid string, client_secret string, region string, cache *OneLoginCache, client *http.Client) (*OneLogin, error) {
+	if cache == nil {
+		cache = LoadOneLoginCache("")
+	}
 	o := OneLogin{
-		Cache: cache,
+		Cache:        cache,
+		clientId:     clientid,
+		clientSecret: client_secret,
 	}
 
 	if clientid == "" {
@@ -80,65 +91,85 @@ func NewOneLogin(clientid string, client_secret string, region string) (*OneLogi
 	if region == "" {
 		region = "us"
 	}
-	token, err := o.Cache.GetAccessToken()
-	if err != nil || token == "" {
-
-		o.Url = fmt.Sprintf("https://api.%s.onelogin.com", region)
+	o.Url = fmt.Sprintf("https://api.%s.onelogin.com", region)
+	if client != nil {
+		o.Client = resty.NewWithClient(client)
+	} else {
 		o.Client = resty.New()
-		o.Client.SetHeader("Content-Type", "application/json")
-		o.Client.SetHeader("Accept", "application/json")
-
-		data := map[string]string{
-			"grant_type": "client_credentials",
-		}
-
-		url := fmt.Sprintf("%s/auth/oauth2/v2/token", o.Url)
-		body, _ := json.Marshal(data)
-		resp, err := o.Client.R().
-			SetBasicAuth(clientid, client_secret).
-			SetResult(&AccessTokenResponse{}).
-			SetBody(body).
-			Post(url)
-		if err != nil {
-			log.Fatalf("Unable to auth with clientid/client_secret: %s", err)
-		} else if resp.IsError() {
-			log.Fatalf("Unable auth with clientid/client_secret: %s [%d]", resp.String(), resp.StatusCode())
-		}
-
-		result := resp.Result().(*AccessTokenResponse)
-		token = result.AccessToken
-		o.Cache.SaveAccessToken(result)
+	}
+	o.Client.SetHeader("Content-Type", "application/json")
+	o.Client.SetHeader("Accept", "application/json")
+
+	err := o.RefreshAccessToken()
+	if err != nil {
+		return nil, err
+	}
+	return &o, nil
+}
+
+/*
+ * Gets a new OAuth2 token if ours is about to expire.  Programs which keep
+ * a OneLogin around for longer than the token is valid should call this
+ * before logging in.
+ */
+func (o *OneLogin) RefreshAccessToken() error {
+	token := o.Cache.AccessToken
+	if expires, err := token.ExpiresAt(); err == nil && token.AccessToken != "" &&
+		time.Now().Add(ACCESS_TOKEN_WINDOW).Before(expires) {
+		o.setAccessToken(token.AccessToken)
+		return nil
 	}
 
-	// make other API calls shorter
+	data := map[string]string{
+		"grant_type": "client_credentials",
+	}
+	url := fmt.Sprintf("%s/auth/oauth2/v2/token", o.Url)
+	body, _ := json.Marshal(data)
+	// o.Client would send our old token instead of the client credentials
+	resp, err := resty.NewWithClient(o.Client.GetClient()).R().
+		SetHeader("Content-Type", "application/json").
+		SetHeader("Accept", "application/json").
+		SetBasicAuth(o.clientId, o.clientSecret).
+		SetResult(&AccessTokenResponse{}).
+		SetBody(body).
+		Post(url)
+	if err != nil {
+		return networkError("auth with clientid/client_secret", err)
+	} else if resp.IsError() {
+		return responseError("auth with clientid/client_secret", resp, ErrOAuthFailed)
+	}
+
+	result := resp.Result().(*AccessTokenResponse)
+	o.setAccessToken(result.AccessToken)
+	if err = o.Cache.SaveAccessToken(result); err != nil {
+		log.WithError(err).Warn("Unable to cache OneLogin OAuth2 token")
+	}
+	return nil
+}
+
+// make other API calls shorter
+func (o *OneLogin) setAccessToken(token string) {
+	o.AccessToken = token
 	o.Client.SetAuthToken(token).
 		SetHeader("Content-Type", "application/json")
-
-	return &o, nil
 }
 
 // returns true if the given OAuth2 token has expired
-func (token *AccessTokenResponse) IsExpired() bool {
-	created_at, err := time.Parse("2006-01-02T15:04:05.000Z", token.CreatedAt)
+func (token *AccessTokenResponse) IsExpired() (bool, error) {
+	expires_at, err := token.ExpiresAt()
 	if err != nil {
-		log.Fatalf("Unable to parse %s: %s", token.CreatedAt, err.Error())
-	}
-	expires_at := created_at.Add(time.Second * time.Duration(token.ExpiresIn))
-	now := time.Now()
-	if now.After(expires_at) {
-		return true
+		return true, err
 	}
-	return false
+	return time.Now().After(expires_at), nil
 }
 
 // returns when our token expires
-func (token *AccessTokenResponse) ExpiresAt() string {
+func (token *AccessTokenResponse) ExpiresAt() (time.Time, error) {
 	created_at, err := time.Parse("2006-01-02T15:04:05.000Z", token.CreatedAt)
 	if err != nil {
-		log.Fatalf("Unable to parse %s: %s", token.CreatedAt, err.Error())
+		return time.Time{}, fmt.Errorf("Unable to parse %s: %s", token.CreatedAt, err.Error())
 	}
-	expires_at := created_at.Add(time.Second * time.Duration(token.ExpiresIn))
-	return expires_at.Local().String()
+	return created_at.Add(time.Second * time.Duration(token.ExpiresIn)), nil
 }
 
 type RateLimit struct {
@@ -165,9 +196,9 @@ func (o *OneLogin) GetRateLimit() (*RateLimit, error) {
 		SetResult(&RateLimit{}).
 		Post(url)
 	if err != nil {
-		return nil, fmt.Errorf("Unable to get rate_limit for %s: %s", url, err.Error())
+		return nil, networkError(fmt.Sprintf("get rate_limit for %s", url), err)
 	} else if resp.IsError() {
-		return nil, fmt.Errorf("Unable to get rate_limit for %s: %s [%d]", url, resp.String(), resp.StatusCode())
+		return nil, responseError(fmt.Sprintf("get rate_limit for %s", url), resp, nil)
 	}
 	result := resp.Result().(*RateLimit)
 	log.Debugf("RateLimit: %s", resp.String())
@@ -0,0 +1,107 @@
+package onelogin
+
+/*
+ * OneLogin AWS Role
+ * Copyright (c) 2020-2021 Aaron Turner  <aturner at synfin dot net>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+/*
+ * All interaction with the user while logging in goes through a Prompter
+ * so that programs which embed this package can provide their own UI.
+ * The OneLogin password is passed to GetAssertion() by the caller.
+ */
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/Songmu/prompter"
+	log "github.com/sirupsen/logrus"
+	"github.com/synfinatic/onelogin-aws-role/utils"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+type Prompter interface {
+	// Returns the DeviceId of the MFA device the user selects
+	SelectMfaDevice(devices []MfaDevice) (int32, error)
+	// Returns the MFA code the user entered
+	MfaCode(prompt string) (string, error)
+	// Returns true if the user answers yes
+	Confirm(prompt string, def bool) (bool, error)
+	// Displays a message, such as where an MFA code was sent
+	Message(msg string)
+}
+
+// The user did not make a valid selection
+var ErrNoSelection = errors.New("No valid selection")
+
+// We need to prompt the user, but stdin is not a terminal
+var ErrNoTTY = errors.New("Unable to prompt for MFA without a TTY")
+
+// How many invalid selections we accept before giving up
+const PROMPT_ATTEMPTS = 10
+
+// Prompter which uses the terminal
+type TerminalPrompter struct{}
+
+func (tp TerminalPrompter) SelectMfaDevice(devices []MfaDevice) (int32, error) {
+	if !terminal.IsTerminal(int(os.Stdin.Fd())) {
+		return 0, ErrNoTTY
+	}
+	m := GenerateMfaSelect(devices)
+	mfaSelect := *m
+	fields := []string{
+		"Select",
+		"DeviceType",
+		"DeviceId",
+	}
+
+	ts := []utils.TableStruct{}
+	for _, mfa := range mfaSelect {
+		ts = append(ts, mfa)
+	}
+	utils.GenerateTable(ts, fields)
+	fmt.Printf("\n")
+
+	for i := 0; i < PROMPT_ATTEMPTS; i++ {
+		sel := prompter.Prompt("Select MFA Device", "")
+		x, err := strconv.ParseInt(sel, 10, 32)
+		if err != nil || x > int64(len(devices)) || x < 1 {
+			log.Errorf("Invalid MFA selector: please choose 1-%d", len(devices))
+			continue
+		}
+		return mfaSelect[x-1].DeviceId, nil
+	}
+	return 0, fmt.Errorf("Unable to select MFA device: %w", ErrNoSelection)
+}
+
+func (tp TerminalPrompter) MfaCode(prompt string) (string, error) {
+	// prompter silently returns "" when stdin isn't a terminal
+	if !terminal.IsTerminal(int(os.Stdin.Fd())) {
+		return "", ErrNoTTY
+	}
+	return prompter.Prompt(prompt, ""), nil
+}
+
+func (tp TerminalPrompter) Confirm(prompt string, def bool) (bool, error) {
+	return prompter.YN(prompt, def), nil
+}
+
+func (tp TerminalPrompter) Message(msg string) {
+	fmt.Printf("%s\n", msg)
+}
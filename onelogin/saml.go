@@ -21,11 +21,12 @@ package onelogin
 import (
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"strconv"
+	"strings"
 	"time"
 
-	"github.com/Songmu/prompter"
 	"github.com/aws/aws-sdk-go/service/sts"
 	log "github.com/sirupsen/logrus"
 )
@@ -36,11 +37,51 @@ const (
 	MFAInvalid MFAType = iota
 	MFAOneLoginPush
 	MFACode
+	MFASMS   // code must be triggered before it is sent
+	MFAEmail // code must be triggered before it is sent
+	MFAVoice // code must be triggered before it is sent
 )
 
+// special response to our MFA prompt to have OneLogin send another code
+const MFA_RESEND = "resend"
+
+// how many times we prompt for an MFA code before giving up
+const MFA_ATTEMPTS = 10
+
 type OneLoginSAML struct {
-	OneLogin *OneLogin
-	Response *SAMLResponse
+	OneLogin    *OneLogin
+	Response    *SAMLResponse
+	Prompter    Prompter    // how we interact with the user
+	TOTPLookup  TOTPLookup  // optional source of TOTP seeds for MFA devices
+	PushOptions PushOptions // how long & often to poll OneLogin Protect
+}
+
+// Controls how we poll OneLogin for the status of a OneLogin Protect push
+type PushOptions struct {
+	Timeout  time.Duration                 // how long to wait for the user
+	Interval time.Duration                 // time between the first polls
+	Backoff  float64                       // multiplier applied to Interval after each poll
+	Progress func(remaining time.Duration) // optional, called about every second while waiting
+}
+
+const (
+	PUSH_DEFAULT_TIMEOUT  = 60 * time.Second
+	PUSH_DEFAULT_INTERVAL = 1 * time.Second
+	PUSH_DEFAULT_BACKOFF  = 1.0
+)
+
+// Fills in any unset values with our defaults
+func (po PushOptions) withDefaults() PushOptions {
+	if po.Timeout <= 0 {
+		po.Timeout = PUSH_DEFAULT_TIMEOUT
+	}
+	if po.Interval <= 0 {
+		po.Interval = PUSH_DEFAULT_INTERVAL
+	}
+	if po.Backoff < 1.0 {
+		po.Backoff = PUSH_DEFAULT_BACKOFF
+	}
+	return po
 }
 
 type SAMLResponse struct {
@@ -70,6 +111,7 @@ func (sr *SAMLResponse) NewMFA(o *OneLogin) *MFA {
 func NewOneLoginSAML(o *OneLogin) *OneLoginSAML {
 	ols := OneLoginSAML{
 		OneLogin: o,
+		Prompter: TerminalPrompter{},
 	}
 
 	return &ols
@@ -83,9 +125,15 @@ func (ols *OneLoginSAML) GetAssertion(username string, password string, subdomai
 		return false, nil
 	} else {
 		log.Debugf("Unable to load assertion: %s", err.Error())
-		err = nil
 	}
+	return ols.RequestAssertion(username, password, subdomain, app_id, ip)
+}
 
+/*
+ * Always asks OneLogin for a new SAML assertion, ignoring our cache.
+ * Returns true/false if MFA is required, list of devices is in ols.Response.Devices
+ */
+func (ols *OneLoginSAML) RequestAssertion(username string, password string, subdomain string, app_id uint32, ip string) (bool, error) {
 	url := fmt.Sprintf("%s/api/2/saml_assertion", ols.OneLogin.Url)
 
 	data := map[string]string{
@@ -105,9 +153,9 @@ func (ols *OneLoginSAML) GetAssertion(username string, password string, subdomai
 		SetBody(body).
 		Post(url)
 	if err != nil {
-		return false, err
+		return false, networkError("get SAML assertion", err)
 	} else if resp.IsError() {
-		return false, fmt.Errorf("Unable to GetAssertion: %s [%d]", resp.String(), resp.StatusCode())
+		return false, responseError("get SAML assertion", resp, ErrInvalidCredentials)
 	}
 	result := resp.Result().(*SAMLResponse)
 	if result.Data != "" {
@@ -124,9 +172,16 @@ func (ols *OneLoginSAML) GetAssertion(username string, password string, subdomai
 
 		log.Debugf("result.Data = %s", result.Data)
 		return false, nil
-	} else {
-		log.Debug("no Data, MFA required")
 	}
+
+	// OneLogin may tell us about these states without an HTTP error
+	if passwordExpired(result.Message) {
+		return false, &OneLoginError{Kind: ErrPasswordExpired, Action: "get SAML assertion", Message: result.Message}
+	} else if mfaEnrollment(result.Message) || len(result.Devices) == 0 {
+		return false, &OneLoginError{Kind: ErrMFAEnrollment, Action: "get SAML assertion", Message: result.Message}
+	}
+
+	log.Debug("no Data, MFA required")
 	ols.Response = result
 	return true, nil
 }
@@ -134,16 +189,29 @@ func (ols *OneLoginSAML) GetAssertion(username string, password string, subdomai
 func (ols *OneLoginSAML) GetMfaType(deviceId int32) (MFAType, error) {
 	for _, device := range ols.Response.Devices {
 		if deviceId == device.DeviceId {
-			if device.DeviceType == "OneLogin Protect" {
-				return MFAOneLoginPush, nil
-			} else {
-				return MFACode, nil
-			}
+			return mfaTypeFromDeviceType(device.DeviceType), nil
 		}
 	}
 	return MFAInvalid, fmt.Errorf("Configured MFA deviceId is not valid")
 }
 
+// Maps the OneLogin device_type to how we need to handle it
+func mfaTypeFromDeviceType(deviceType string) MFAType {
+	dt := strings.ToLower(deviceType)
+	switch {
+	case deviceType == "OneLogin Protect":
+		return MFAOneLoginPush
+	case strings.Contains(dt, "sms"):
+		return MFASMS
+	case strings.Contains(dt, "email"):
+		return MFAEmail
+	case strings.Contains(dt, "voice"):
+		return MFAVoice
+	default:
+		return MFACode
+	}
+}
+
 func (ols *OneLoginSAML) GetMfaTypeString(deviceId int32) (string, error) {
 	for _, device := range ols.Response.Devices {
 		if deviceId == device.DeviceId {
@@ -154,49 +222,145 @@ func (ols *OneLoginSAML) GetMfaTypeString(deviceId int32) (string, error) {
 
 }
 
+/*
+ * Picks the MFA device to use without prompting the user.  The device_id is used
+ * if the user still has it enrolled, otherwise the first device matching the
+ * ordered list of device types.  Returns 0 if the user needs to be prompted.
+ */
+func (ols *OneLoginSAML) PickMfaDevice(deviceId int32, preferences []string) int32 {
+	if deviceId != 0 {
+		for _, device := range ols.Response.Devices {
+			if device.DeviceId == deviceId {
+				return deviceId
+			}
+		}
+		log.Warnf("Configured MFA device %d is not enrolled for your account", deviceId)
+	}
+
+	for _, pref := range preferences {
+		for _, device := range ols.Response.Devices {
+			if strings.EqualFold(device.DeviceType, pref) {
+				log.Debugf("Selected MFA device %d (%s)", device.DeviceId, device.DeviceType)
+				return device.DeviceId
+			}
+		}
+	}
+	return 0
+}
+
 // Returns the deviceId of a MFA device that the user selects
 func (ols *OneLoginSAML) PromptMFA() (int32, error) {
-	return 0, nil
+	return ols.selectMfaDevice(ols.Response.Devices)
+}
+
+// Only prompts the user if there is more than one device to choose from
+func (ols *OneLoginSAML) selectMfaDevice(devices []MfaDevice) (int32, error) {
+	switch len(devices) {
+	case 0:
+		return 0, ErrMFAEnrollment
+	case 1:
+		return devices[0].DeviceId, nil
+	}
+	return ols.prompter().SelectMfaDevice(devices)
+}
+
+func (ols *OneLoginSAML) prompter() Prompter {
+	if ols.Prompter == nil {
+		return TerminalPrompter{}
+	}
+	return ols.Prompter
 }
 
 // Handles sending the MFA code or Push MFA.  Returns true/false if we got our Assertion
 func (ols *OneLoginSAML) SubmitMFA(deviceId int32, appid uint32) (bool, error) {
 	mfa_auth_pass := false
 
+	if len(ols.Response.Devices) == 0 {
+		return false, ErrMFAEnrollment
+	}
+	var err error
 	if deviceId == 0 {
-		deviceId = SelectMfaDevice(ols.Response.Devices)
+		deviceId, err = ols.PromptMFA()
+		if err != nil {
+			return false, err
+		}
 	}
 	deviceType, err := ols.GetMfaType(deviceId)
 	if err != nil {
 		return false, err
 	}
+
+	// If we have the TOTP seed for this device, we don't need to bother the user
+	if ols.TOTPLookup != nil {
+		totp, err := ols.TOTPLookup(deviceId)
+		if err == nil {
+			mfa_auth_pass, err = ols.SubmitTOTP(appid, deviceId, totp)
+			if err == nil && mfa_auth_pass {
+				return true, nil
+			}
+			log.Warnf("Unable to authenticate with stored TOTP seed for MFA device %d", deviceId)
+		} else {
+			log.Debugf("No TOTP seed for MFA device %d: %s", deviceId, err.Error())
+		}
+	}
+
 	switch deviceType {
 	case MFAOneLoginPush:
-		// FIXME: make this count configurable?
-		mfa_auth_pass, err = ols.OneLoginProtectPush(appid, 10)
+		mfa_auth_pass, err = ols.OneLoginProtectPush(appid, ols.PushOptions)
+		if errors.Is(err, ErrPushTimeout) && len(ols.Response.Devices) > 1 {
+			retry, perr := ols.prompter().Confirm("OneLogin Protect push expired.  Use another MFA device?", true)
+			if perr == nil && retry {
+				others := []MfaDevice{}
+				for _, device := range ols.Response.Devices {
+					if device.DeviceId != deviceId {
+						others = append(others, device)
+					}
+				}
+				other, err := ols.selectMfaDevice(others)
+				if err != nil {
+					return false, err
+				}
+				return ols.SubmitMFA(other, appid)
+			}
+		}
 		if err != nil {
-			return mfa_auth_pass, fmt.Errorf("Error doing OneLogin Protect Push authentication: %s", err.Error())
+			return mfa_auth_pass, fmt.Errorf("Error doing OneLogin Protect Push authentication: %w", err)
 		}
 
 	case MFACode:
-		mfa_attempts := 10 // FIXME: make this count configurable?
 		name, err := ols.GetMfaTypeString(deviceId)
 		if err != nil {
 			return false, err
 		}
-		for i := 0; i < mfa_attempts && !mfa_auth_pass; i++ {
-			prompt := fmt.Sprintf("Enter your %s code", name)
-			mfa_str := prompter.Prompt(prompt, "")
-			mfa_code, err := strconv.ParseInt(mfa_str, 10, 32)
-			if err != nil {
-				log.Errorf("Invalid MFA Code.  Must be valid integer")
-				continue
-			}
-			mfa_auth_pass, err = ols.SubmitMFACode(appid, deviceId, int32(mfa_code))
+		prompt := fmt.Sprintf("Enter your %s code", name)
+		mfa_auth_pass, err = ols.promptMfaCode(appid, deviceId, prompt, true, nil)
+		if err != nil {
+			return false, err
+		}
+
+	case MFASMS, MFAEmail, MFAVoice:
+		name, err := ols.GetMfaTypeString(deviceId)
+		if err != nil {
+			return false, err
+		}
+		msg, err := ols.TriggerMFA(appid, deviceId)
+		if err != nil {
+			return false, fmt.Errorf("Error sending %s code: %s", name, err.Error())
+		}
+		ols.prompter().Message(msg)
+		resend := func() {
+			msg, err := ols.TriggerMFA(appid, deviceId)
 			if err != nil {
-				log.Error("Invalid MFA code.")
+				log.Errorf("Unable to resend %s code: %s", name, err.Error())
+			} else {
+				ols.prompter().Message(msg)
 			}
 		}
+		prompt := fmt.Sprintf("Enter your %s code (or '%s')", name, MFA_RESEND)
+		mfa_auth_pass, err = ols.promptMfaCode(appid, deviceId, prompt, false, resend)
+		if err != nil {
+			return false, err
+		}
 
 	default:
 		return false, fmt.Errorf("Unsupported MFAType: %d", deviceType)
@@ -204,12 +368,78 @@ func (ols *OneLoginSAML) SubmitMFA(deviceId int32, appid uint32) (bool, error) {
 	return mfa_auth_pass, nil
 }
 
+/*
+ * Prompts for MFA codes until one is accepted or we run out of attempts.
+ * If resend is not nil, the user can ask for another code by entering
+ * MFA_RESEND.
+ */
+func (ols *OneLoginSAML) promptMfaCode(appid uint32, deviceId int32, prompt string, numeric bool, resend func()) (bool, error) {
+	for i := 0; i < MFA_ATTEMPTS; i++ {
+		mfa_str, err := ols.prompter().MfaCode(prompt)
+		if err != nil {
+			return false, err
+		}
+		mfa_str = strings.TrimSpace(mfa_str)
+		if resend != nil && strings.ToLower(mfa_str) == MFA_RESEND {
+			resend()
+			continue
+		} else if mfa_str == "" {
+			log.Errorf("Invalid MFA Code.  Must not be empty")
+			continue
+		} else if _, err = strconv.ParseInt(mfa_str, 10, 32); numeric && err != nil {
+			log.Errorf("Invalid MFA Code.  Must be valid integer")
+			continue
+		}
+		success, err := ols.SubmitMFACode(appid, deviceId, mfa_str)
+		if errors.Is(err, ErrMFAFailed) {
+			log.Error("Invalid MFA code.")
+		} else if err != nil {
+			return false, err
+		} else if success {
+			return true, nil
+		}
+	}
+	return false, fmt.Errorf("%w: too many attempts", ErrMFAFailed)
+}
+
+// Generates the current TOTP code locally and submits it.  Returns true/false if we got our assertion
+func (ols *OneLoginSAML) SubmitTOTP(app_id uint32, device_id int32, totp *TOTPConfig) (bool, error) {
+	code, err := totp.Generate(time.Now())
+	if err != nil {
+		return false, err
+	}
+	log.Infof("Using stored TOTP seed for MFA device %d", device_id)
+	return ols.SubmitMFACode(app_id, device_id, code)
+}
+
+// Asks OneLogin to deliver a code to the device.  Returns where it was sent.
+func (ols *OneLoginSAML) TriggerMFA(app_id uint32, device_id int32) (string, error) {
+	mfa := ols.Response.NewMFA(ols.OneLogin)
+	mfa.SetParam("app_id", fmt.Sprintf("%d", app_id))
+
+	resp, err := mfa.TriggerMFA(device_id)
+	if err != nil {
+		return "", err
+	}
+	log.Debugf("MFA trigger: %s", resp)
+	sr := SAMLResponse{}
+	err = json.Unmarshal([]byte(resp), &sr)
+	if err != nil {
+		return "", err
+	}
+	if sr.Message == "" {
+		name, _ := ols.GetMfaTypeString(device_id)
+		return fmt.Sprintf("%s code sent", name), nil
+	}
+	return sr.Message, nil
+}
+
 // Returns true/false if we got our assertion
-func (ols *OneLoginSAML) SubmitMFACode(app_id uint32, device_id int32, mfa_code int32) (bool, error) {
+func (ols *OneLoginSAML) SubmitMFACode(app_id uint32, device_id int32, otp string) (bool, error) {
 	mfa := ols.Response.NewMFA(ols.OneLogin)
 	mfa.SetParam("app_id", fmt.Sprintf("%d", app_id))
 
-	resp, err := mfa.SubmitMFA(device_id, mfa_code)
+	resp, err := mfa.SubmitMFA(device_id, otp)
 	if err != nil {
 		return false, err
 	}
@@ -235,8 +465,13 @@ func (ols *OneLoginSAML) SubmitMFACode(app_id uint32, device_id int32, mfa_code
 	return false, nil
 }
 
-// Returns true/false if we got our assertion
-func (ols *OneLoginSAML) OneLoginProtectPush(app_id uint32, tries uint32) (bool, error) {
+/*
+ * Sends a OneLogin Protect push and then polls until the user approves it.
+ * Returns true if we got our assertion.  If the user denies the push we return
+ * ErrPushDenied and if they never respond we return ErrPushTimeout.
+ */
+func (ols *OneLoginSAML) OneLoginProtectPush(app_id uint32, opts PushOptions) (bool, error) {
+	opts = opts.withDefaults()
 	mfa := ols.Response.NewMFA(ols.OneLogin)
 	mfa.SetParam("app_id", fmt.Sprintf("%d", app_id))
 
@@ -248,13 +483,38 @@ func (ols *OneLoginSAML) OneLoginProtectPush(app_id uint32, tries uint32) (bool,
 	log.Debugf("First MFA Push: %s", resp)
 	sr := SAMLResponse{}
 	err = json.Unmarshal([]byte(resp), &sr)
+	if err != nil {
+		return false, fmt.Errorf("Error parsing OneLogin Protect response: %s", err.Error())
+	}
+
+	deadline := time.Now().Add(opts.Timeout)
+	interval := opts.Interval
+	for sr.Data == "" {
+		if pushDenied(sr.Message) {
+			return false, fmt.Errorf("%w: %s", ErrPushDenied, sr.Message)
+		}
 
-	var i uint32
-	for i = 0; i < tries; i++ {
-		if sr.Data != "" {
-			break
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return false, ErrPushTimeout
+		}
+		wait := interval
+		if wait > remaining {
+			wait = remaining
+		}
+		// sleep in one second steps so the caller can display a countdown
+		for wait > 0 {
+			if opts.Progress != nil {
+				opts.Progress(time.Until(deadline))
+			}
+			step := time.Second
+			if step > wait {
+				step = wait
+			}
+			time.Sleep(step)
+			wait -= step
 		}
-		time.Sleep(1 * time.Second)
+
 		resp, err = mfa.OneLoginProtectPush(false)
 		if err != nil {
 			return false, err
@@ -262,25 +522,33 @@ func (ols *OneLoginSAML) OneLoginProtectPush(app_id uint32, tries uint32) (bool,
 		log.Debugf("OLPP result: %s", resp)
 		err = json.Unmarshal([]byte(resp), &sr)
 		if err != nil {
-			log.WithError(err).Fatalf("Error parsing JSON response")
+			return false, fmt.Errorf("Error parsing OneLogin Protect response: %s", err.Error())
 		}
+		interval = time.Duration(float64(interval) * opts.Backoff)
 	}
 
-	if sr.Data != "" {
-		decoded, err := base64.StdEncoding.DecodeString(sr.Data)
-		if err != nil {
-			return false, fmt.Errorf("Unable to decode assertion: %s", err.Error())
-		}
+	decoded, err := base64.StdEncoding.DecodeString(sr.Data)
+	if err != nil {
+		return false, fmt.Errorf("Unable to decode assertion: %s", err.Error())
+	}
 
-		// save our assertion in the cache for later
-		err = ols.OneLogin.Cache.SaveAssertion(app_id, string(decoded))
-		if err != nil {
-			log.Warn(err.Error())
+	// save our assertion in the cache for later
+	err = ols.OneLogin.Cache.SaveAssertion(app_id, string(decoded))
+	if err != nil {
+		log.Warn(err.Error())
+	}
+	return true, nil
+}
+
+// Does this OneLogin Protect status message mean the user denied the push?
+func pushDenied(message string) bool {
+	msg := strings.ToLower(message)
+	for _, word := range []string{"denied", "rejected", "declined"} {
+		if strings.Contains(msg, word) {
+			return true
 		}
-		return true, nil
 	}
-	// timed out
-	return false, nil
+	return false
 }
 
 func (ols *OneLoginSAML) HasAssertion(app_id uint32) bool {
@@ -0,0 +1,176 @@
+package onelogin
+
+/*
+ * OneLogin AWS Role
+ * Copyright (c) 2020-2021 Aaron Turner  <aturner at synfin dot net>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+/*
+ * RFC 6238 TOTP code generator so that users who have the seed for their
+ * authenticator app MFA device don't have to type in the code.
+ */
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	TOTP_DEFAULT_DIGITS    = 6
+	TOTP_DEFAULT_PERIOD    = 30
+	TOTP_DEFAULT_ALGORITHM = "SHA1"
+)
+
+type TOTPConfig struct {
+	Secret    string `json:"secret"` // base32 encoded
+	Digits    int    `json:"digits"`
+	Period    int64  `json:"period"` // seconds
+	Algorithm string `json:"algorithm"`
+}
+
+// Returns the TOTPConfig for the given MFA device or an error if we don't have one
+type TOTPLookup func(device_id int32) (*TOTPConfig, error)
+
+/*
+ * Parse either a base32 seed or an otpauth://totp/ URI as exported by
+ * most authenticator apps & password managers
+ */
+func ParseTOTP(seed string) (*TOTPConfig, error) {
+	seed = strings.TrimSpace(seed)
+	t := TOTPConfig{
+		Secret:    seed,
+		Digits:    TOTP_DEFAULT_DIGITS,
+		Period:    TOTP_DEFAULT_PERIOD,
+		Algorithm: TOTP_DEFAULT_ALGORITHM,
+	}
+
+	if strings.HasPrefix(strings.ToLower(seed), "otpauth://") {
+		u, err := url.Parse(seed)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to parse otpauth URI: %s", err.Error())
+		}
+		if strings.ToLower(u.Host) != "totp" {
+			return nil, fmt.Errorf("Unsupported otpauth type: %s", u.Host)
+		}
+		q := u.Query()
+		t.Secret = q.Get("secret")
+		if d := q.Get("digits"); d != "" {
+			t.Digits, err = strconv.Atoi(d)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid otpauth digits: %s", d)
+			}
+		}
+		if p := q.Get("period"); p != "" {
+			t.Period, err = strconv.ParseInt(p, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid otpauth period: %s", p)
+			}
+		}
+		if a := q.Get("algorithm"); a != "" {
+			t.Algorithm = strings.ToUpper(a)
+		}
+	}
+
+	// normalize the secret the way authenticator apps display it
+	t.Secret = strings.ToUpper(strings.ReplaceAll(t.Secret, " ", ""))
+	t.Secret = strings.TrimRight(t.Secret, "=")
+	if err := t.Validate(); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// Make sure our TOTPConfig is usable
+func (t *TOTPConfig) Validate() error {
+	if t.Secret == "" {
+		return fmt.Errorf("Missing TOTP secret")
+	}
+	if _, err := t.key(); err != nil {
+		return err
+	}
+	if t.Digits < 6 || t.Digits > 8 {
+		return fmt.Errorf("Invalid TOTP digits: %d", t.Digits)
+	}
+	if t.Period < 1 {
+		return fmt.Errorf("Invalid TOTP period: %d", t.Period)
+	}
+	if _, err := t.hash(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (t *TOTPConfig) key() ([]byte, error) {
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(t.Secret)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid base32 TOTP secret: %s", err.Error())
+	}
+	return key, nil
+}
+
+func (t *TOTPConfig) hash() (func() hash.Hash, error) {
+	switch t.Algorithm {
+	case "", "SHA1":
+		return sha1.New, nil
+	case "SHA256":
+		return sha256.New, nil
+	case "SHA512":
+		return sha512.New, nil
+	}
+	return nil, fmt.Errorf("Unsupported TOTP algorithm: %s", t.Algorithm)
+}
+
+// Generate the TOTP code for the given time
+func (t *TOTPConfig) Generate(now time.Time) (string, error) {
+	// TOTPConfig may not have come from ParseTOTP
+	if err := t.Validate(); err != nil {
+		return "", err
+	}
+	key, err := t.key()
+	if err != nil {
+		return "", err
+	}
+	h, err := t.hash()
+	if err != nil {
+		return "", err
+	}
+
+	counter := make([]byte, 8)
+	binary.BigEndian.PutUint64(counter, uint64(now.Unix()/t.Period))
+	mac := hmac.New(h, key)
+	mac.Write(counter)
+	sum := mac.Sum(nil)
+
+	// RFC 4226 dynamic truncation
+	offset := sum[len(sum)-1] & 0x0f
+	code := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < t.Digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", t.Digits, code%mod), nil
+}
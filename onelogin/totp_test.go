@@ -0,0 +1,120 @@
+package onelogin
+
+/*
+ * OneLogin AWS Role
+ * Copyright (c) 2020-2021 Aaron Turner  <aturner at synfin dot net>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+)
+
+// RFC 6238 Appendix B
+func TestGenerateRFC6238(t *testing.T) {
+	seeds := map[string]string{
+		"SHA1":   "12345678901234567890",
+		"SHA256": "12345678901234567890123456789012",
+		"SHA512": "1234567890123456789012345678901234567890123456789012345678901234",
+	}
+	tests := []struct {
+		unix  int64
+		codes map[string]string
+	}{
+		{59, map[string]string{"SHA1": "94287082", "SHA256": "46119246", "SHA512": "90693936"}},
+		{1111111109, map[string]string{"SHA1": "07081804", "SHA256": "68084774", "SHA512": "25091201"}},
+		{1111111111, map[string]string{"SHA1": "14050471", "SHA256": "67062674", "SHA512": "99943326"}},
+		{1234567890, map[string]string{"SHA1": "89005924", "SHA256": "91819424", "SHA512": "93441116"}},
+		{2000000000, map[string]string{"SHA1": "69279037", "SHA256": "90698825", "SHA512": "38618901"}},
+		{20000000000, map[string]string{"SHA1": "65353130", "SHA256": "77737706", "SHA512": "47863826"}},
+	}
+
+	for _, test := range tests {
+		for algorithm, want := range test.codes {
+			totp := TOTPConfig{
+				Secret:    base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte(seeds[algorithm])),
+				Digits:    8,
+				Period:    30,
+				Algorithm: algorithm,
+			}
+			code, err := totp.Generate(time.Unix(test.unix, 0))
+			if err != nil {
+				t.Errorf("%s at %d: %s", algorithm, test.unix, err.Error())
+			} else if code != want {
+				t.Errorf("%s at %d: got %s, want %s", algorithm, test.unix, code, want)
+			}
+		}
+	}
+}
+
+func TestGenerateInvalid(t *testing.T) {
+	tests := []TOTPConfig{
+		{Secret: "JBSWY3DPEHPK3PXP", Digits: 6, Period: 0, Algorithm: "SHA1"},
+		{Secret: "JBSWY3DPEHPK3PXP", Digits: 0, Period: 30, Algorithm: "SHA1"},
+		{Secret: "not base32!", Digits: 6, Period: 30, Algorithm: "SHA1"},
+		{Secret: "JBSWY3DPEHPK3PXP", Digits: 6, Period: 30, Algorithm: "MD5"},
+		{},
+	}
+	for _, totp := range tests {
+		if _, err := totp.Generate(time.Now()); err == nil {
+			t.Errorf("expected an error for %+v", totp)
+		}
+	}
+}
+
+func TestParseTOTP(t *testing.T) {
+	tests := []struct {
+		seed string
+		want TOTPConfig
+	}{
+		{"JBSWY3DPEHPK3PXP", TOTPConfig{"JBSWY3DPEHPK3PXP", 6, 30, "SHA1"}},
+		{" jbsw y3dp ehpk 3pxp ", TOTPConfig{"JBSWY3DPEHPK3PXP", 6, 30, "SHA1"}},
+		{"JBSWY3DPEHPK3PXP====", TOTPConfig{"JBSWY3DPEHPK3PXP", 6, 30, "SHA1"}},
+		{"otpauth://totp/OneLogin:user@example.com?secret=JBSWY3DPEHPK3PXP&issuer=OneLogin",
+			TOTPConfig{"JBSWY3DPEHPK3PXP", 6, 30, "SHA1"}},
+		{"otpauth://totp/OneLogin?secret=jbswy3dpehpk3pxp&digits=8&period=60&algorithm=sha256",
+			TOTPConfig{"JBSWY3DPEHPK3PXP", 8, 60, "SHA256"}},
+		{"OTPAUTH://TOTP/OneLogin?secret=JBSWY3DPEHPK3PXP&algorithm=SHA512",
+			TOTPConfig{"JBSWY3DPEHPK3PXP", 6, 30, "SHA512"}},
+	}
+	for _, test := range tests {
+		totp, err := ParseTOTP(test.seed)
+		if err != nil {
+			t.Errorf("%s: %s", test.seed, err.Error())
+		} else if *totp != test.want {
+			t.Errorf("%s: got %+v, want %+v", test.seed, *totp, test.want)
+		}
+	}
+}
+
+func TestParseTOTPInvalid(t *testing.T) {
+	tests := []string{
+		"",
+		"not base32!",
+		"otpauth://hotp/OneLogin?secret=JBSWY3DPEHPK3PXP&counter=1",
+		"otpauth://totp/OneLogin?issuer=OneLogin",
+		"otpauth://totp/OneLogin?secret=JBSWY3DPEHPK3PXP&digits=six",
+		"otpauth://totp/OneLogin?secret=JBSWY3DPEHPK3PXP&digits=10",
+		"otpauth://totp/OneLogin?secret=JBSWY3DPEHPK3PXP&period=0",
+		"otpauth://totp/OneLogin?secret=JBSWY3DPEHPK3PXP&algorithm=MD5",
+	}
+	for _, seed := range tests {
+		if _, err := ParseTOTP(seed); err == nil {
+			t.Errorf("expected an error for %s", seed)
+		}
+	}
+}
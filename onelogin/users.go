@@ -0,0 +1,70 @@
+package onelogin
+
+/*
+ * OneLogin AWS Role
+ * Copyright (c) 2020-2021 Aaron Turner  <aturner at synfin dot net>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+/*
+ * OneLogin user management.  These API calls require Oauth credentials with
+ * the appropriate scope: "Read All" for lookups and "Manage users" for changes.
+ */
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Lookup a user by their username or email address
+func (o *OneLogin) GetUser(username string) (*OneLoginUser, error) {
+	url := fmt.Sprintf("%s/api/2/users", o.Url)
+	for _, param := range []string{"username", "email"} {
+		users := []OneLoginUser{}
+		resp, err := o.Client.R().
+			SetQueryParam(param, username).
+			SetResult(&users).
+			Get(url)
+		if err != nil {
+			return nil, networkError(fmt.Sprintf("lookup user %s", username), err)
+		} else if resp.IsError() {
+			return nil, responseError(fmt.Sprintf("lookup user %s", username), resp, nil)
+		}
+		if len(users) > 0 {
+			return &users[0], nil
+		}
+	}
+	return nil, fmt.Errorf("Unable to find OneLogin user: %s", username)
+}
+
+// Sets the password for the given user, enforcing their password policy
+func (o *OneLogin) SetPassword(user_id int64, password string) error {
+	url := fmt.Sprintf("%s/api/1/users/set_password_clear_text/%d", o.Url, user_id)
+	data := map[string]interface{}{
+		"password":              password,
+		"password_confirmation": password,
+		"validate_policy":       true,
+	}
+	body, _ := json.Marshal(data)
+	resp, err := o.Client.R().
+		SetBody(body).
+		Put(url)
+	if err != nil {
+		return networkError("change password", err)
+	} else if resp.IsError() {
+		return responseError("change password", resp, nil)
+	}
+	return nil
+}
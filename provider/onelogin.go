@@ -0,0 +1,173 @@
+package provider
+
+/*
+ * OneLogin AWS Role
+ * Copyright (c) 2020-2021 Aaron Turner  <aturner at synfin dot net>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/synfinatic/onelogin-aws-role/aws"
+	"github.com/synfinatic/onelogin-aws-role/onelogin"
+)
+
+// Where we cache STS sessions, such as the onelogin-aws-role keyring
+type SessionStore interface {
+	GetSTSSession(profile string, session *aws.STSSession) error
+	SaveSTSSession(profile string, session aws.STSSession) error
+}
+
+/*
+ * A Source which works the same as `onelogin-aws-role exec`: use the
+ * cached STS session if it is still valid, otherwise login to OneLogin
+ * and call AssumeRoleWithSAML.
+ */
+type OneLoginSource struct {
+	OneLogin    *onelogin.OneLogin     // from onelogin.NewOneLogin()
+	Username    string                 // OneLogin username or email address
+	Subdomain   string                 // XXXX.onelogin.com
+	Password    func() (string, error) // returns the user's OneLogin password
+	AppId       uint32                 // OneLogin AppID for the AWS account
+	RoleArn     string                 // IAM Role to assume
+	Region      string                 // AWS Region for STS
+	Duration    int64                  // STS session duration in seconds
+	MfaDeviceId int32                  // optional MFA device to use, 0 prompts
+	Prompter    onelogin.Prompter      // optional, defaults to the terminal
+	TOTPLookup  onelogin.TOTPLookup    // optional source of TOTP seeds
+	PushOptions onelogin.PushOptions   // optional OneLogin Protect settings
+	HTTPClient  *http.Client           // optional client for STS
+	Verify      *aws.VerifyOptions     // optional SAML assertion verification
+	Policy      aws.SessionPolicy      // optional session policy
+	Endpoint    aws.STSEndpoint        // optional STS endpoint
+	Store       SessionStore           // optional cache of STS sessions
+	Profile     string                 // key for our session in Store
+	// ignore sessions in Store which expire within this window, default DEFAULT_EXPIRY_WINDOW
+	ExpiryWindow time.Duration
+}
+
+func (ols *OneLoginSource) GetSession() (aws.STSSession, error) {
+	return ols.GetSessionWithContext(context.Background())
+}
+
+func (ols *OneLoginSource) GetSessionWithContext(ctx context.Context) (aws.STSSession, error) {
+	session := aws.STSSession{}
+	if ols.Store != nil {
+		window := ols.ExpiryWindow
+		if window == 0 {
+			window = DEFAULT_EXPIRY_WINDOW
+		}
+		// our providers would immediately ask for a new session otherwise
+		err := ols.Store.GetSTSSession(ols.Profile, &session)
+		if err == nil && time.Now().Add(window).Before(session.Expiration) &&
+			session.PolicyHash == ols.Policy.Hash() {
+			return session, nil
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return aws.STSSession{}, err
+	}
+
+	assertion, err := ols.login()
+	if err != nil {
+		return session, err
+	}
+	if err = ctx.Err(); err != nil {
+		return aws.STSSession{}, err
+	}
+	if ols.Verify != nil {
+		verify := *ols.Verify
+		verify.RoleARN = ols.RoleArn
+		err = aws.VerifyAssertion(assertion, verify)
+		if err != nil {
+			return session, err
+		}
+	}
+
+	duration := ols.Duration
+	if duration == 0 {
+		duration = 3600
+	}
+	session, err = aws.GetSTSSession(assertion, aws.STSOptions{
+		RoleARN:  ols.RoleArn,
+		Region:   ols.Region,
+		Duration: duration,
+		Policy:   ols.Policy,
+		Endpoint: ols.Endpoint,
+		Client:   ols.HTTPClient,
+		Context:  ctx,
+	})
+	if err != nil {
+		return session, err
+	}
+
+	if ols.Store != nil {
+		err = ols.Store.SaveSTSSession(ols.Profile, session)
+		if err != nil {
+			log.WithError(err).Warn("Unable to cache STS Session")
+		}
+	}
+	return session, nil
+}
+
+// Returns a SAML assertion for our app, from our cache if possible
+func (ols *OneLoginSource) login() (string, error) {
+	if ols.OneLogin == nil {
+		return "", fmt.Errorf("OneLoginSource requires a OneLogin client")
+	}
+	cache := ols.OneLogin.Cache
+	assertion, err := cache.GetAssertion(ols.AppId)
+	if err == nil {
+		return assertion, nil
+	}
+	if ols.Password == nil {
+		return "", fmt.Errorf("OneLoginSource requires a Password function")
+	}
+	// we may have been running for longer than our OAuth2 token is valid
+	err = ols.OneLogin.RefreshAccessToken()
+	if err != nil {
+		return "", err
+	}
+
+	password, err := ols.Password()
+	if err != nil {
+		return "", err
+	}
+	saml := onelogin.NewOneLoginSAML(ols.OneLogin)
+	if ols.Prompter != nil {
+		saml.Prompter = ols.Prompter
+	}
+	saml.TOTPLookup = ols.TOTPLookup
+	saml.PushOptions = ols.PushOptions
+
+	need_mfa, err := saml.GetAssertion(ols.Username, password, ols.Subdomain, ols.AppId, "")
+	if err != nil {
+		return "", err
+	}
+	if need_mfa {
+		success, err := saml.SubmitMFA(ols.MfaDeviceId, ols.AppId)
+		if err != nil {
+			return "", err
+		} else if !success {
+			return "", onelogin.ErrMFAFailed
+		}
+	}
+	return cache.GetAssertion(ols.AppId)
+}
@@ -0,0 +1,168 @@
+package provider
+
+/*
+ * OneLogin AWS Role
+ * Copyright (c) 2020-2021 Aaron Turner  <aturner at synfin dot net>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+/*
+ * AWS SDK credential providers backed by onelogin-aws-role so Go programs
+ * can use OneLogin roles directly:
+ *
+ * aws-sdk-go:    credentials.NewCredentials(provider.NewProvider(src))
+ * aws-sdk-go-v2: config.WithCredentialsProvider(awsv2.NewCredentialsCache(provider.NewProviderV2(src)))
+ */
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	awsv2 "github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/synfinatic/onelogin-aws-role/aws"
+)
+
+const PROVIDER_NAME = "OneLoginAWSRole"
+
+// Refresh our credentials this long before they actually expire
+const DEFAULT_EXPIRY_WINDOW = 5 * time.Minute
+
+var _ credentials.Provider = (*Provider)(nil)
+var _ credentials.Expirer = (*Provider)(nil)
+var _ awsv2.CredentialsProvider = (*ProviderV2)(nil)
+
+// Returns a valid STS session
+type Source interface {
+	GetSession() (aws.STSSession, error)
+}
+
+// A Source which can stop retrieving a session when ctx is cancelled
+type ContextSource interface {
+	Source
+	GetSessionWithContext(ctx context.Context) (aws.STSSession, error)
+}
+
+// Allows an ordinary function to be a Source
+type SourceFunc func() (aws.STSSession, error)
+
+func (f SourceFunc) GetSession() (aws.STSSession, error) {
+	return f()
+}
+
+// Implements credentials.Provider and credentials.Expirer for aws-sdk-go
+type Provider struct {
+	Source       Source
+	ExpiryWindow time.Duration // refresh this long before our credentials expire
+	lock         sync.Mutex
+	expiration   time.Time
+}
+
+func NewProvider(source Source) *Provider {
+	return &Provider{
+		Source:       source,
+		ExpiryWindow: DEFAULT_EXPIRY_WINDOW,
+	}
+}
+
+func (p *Provider) Retrieve() (credentials.Value, error) {
+	session, err := p.Source.GetSession()
+	if err != nil {
+		return credentials.Value{ProviderName: PROVIDER_NAME}, err
+	}
+
+	p.lock.Lock()
+	p.expiration = session.Expiration
+	p.lock.Unlock()
+
+	return credentials.Value{
+		AccessKeyID:     session.AccessKeyID,
+		SecretAccessKey: session.SecretAccessKey,
+		SessionToken:    session.SessionToken,
+		ProviderName:    PROVIDER_NAME,
+	}, nil
+}
+
+func (p *Provider) IsExpired() bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return !time.Now().Add(p.ExpiryWindow).Before(p.expiration)
+}
+
+func (p *Provider) ExpiresAt() time.Time {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.expiration
+}
+
+/*
+ * Implements aws.CredentialsProvider for aws-sdk-go-v2.  You should wrap
+ * it in aws.NewCredentialsCache() so the credentials are only refreshed
+ * when they expire.
+ */
+type ProviderV2 struct {
+	Source Source
+}
+
+func NewProviderV2(source Source) *ProviderV2 {
+	return &ProviderV2{
+		Source: source,
+	}
+}
+
+func (p *ProviderV2) Retrieve(ctx context.Context) (awsv2.Credentials, error) {
+	session, err := getSession(ctx, p.Source)
+	if err != nil {
+		return awsv2.Credentials{Source: PROVIDER_NAME}, err
+	}
+	return awsv2.Credentials{
+		AccessKeyID:     session.AccessKeyID,
+		SecretAccessKey: session.SecretAccessKey,
+		SessionToken:    session.SessionToken,
+		Source:          PROVIDER_NAME,
+		CanExpire:       true,
+		Expires:         session.Expiration,
+	}, nil
+}
+
+/*
+ * Returns the session from the source unless ctx is cancelled first.  Sources
+ * which don't support a context keep running in the background.
+ */
+func getSession(ctx context.Context, source Source) (aws.STSSession, error) {
+	if cs, ok := source.(ContextSource); ok {
+		return cs.GetSessionWithContext(ctx)
+	}
+	if err := ctx.Err(); err != nil {
+		return aws.STSSession{}, err
+	}
+
+	type result struct {
+		session aws.STSSession
+		err     error
+	}
+	done := make(chan result, 1)
+	go func() {
+		session, err := source.GetSession()
+		done <- result{session, err}
+	}()
+	select {
+	case r := <-done:
+		return r.session, r.err
+	case <-ctx.Done():
+		return aws.STSSession{}, ctx.Err()
+	}
+}
@@ -0,0 +1,187 @@
+package provider
+
+/*
+ * OneLogin AWS Role
+ * Copyright (c) 2020-2021 Aaron Turner  <aturner at synfin dot net>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/synfinatic/onelogin-aws-role/aws"
+)
+
+type testStore struct {
+	session aws.STSSession
+	saved   bool
+}
+
+func (s *testStore) GetSTSSession(profile string, session *aws.STSSession) error {
+	*session = s.session
+	return nil
+}
+
+func (s *testStore) SaveSTSSession(profile string, session aws.STSSession) error {
+	s.saved = true
+	return nil
+}
+
+func testSession(expires time.Duration) aws.STSSession {
+	return aws.STSSession{
+		AccessKeyID:     "AKIDEXAMPLE",
+		SecretAccessKey: "secret",
+		SessionToken:    "token",
+		Expiration:      time.Now().Add(expires),
+	}
+}
+
+func TestProviderRetrieve(t *testing.T) {
+	p := NewProvider(SourceFunc(func() (aws.STSSession, error) {
+		return testSession(time.Hour), nil
+	}))
+	if !p.IsExpired() {
+		t.Errorf("IsExpired() = false before Retrieve()")
+	}
+	creds, err := p.Retrieve()
+	if err != nil {
+		t.Fatalf("Retrieve(): %s", err)
+	}
+	if creds.AccessKeyID != "AKIDEXAMPLE" || creds.SecretAccessKey != "secret" ||
+		creds.SessionToken != "token" || creds.ProviderName != PROVIDER_NAME {
+		t.Errorf("Retrieve() = %+v", creds)
+	}
+	if p.IsExpired() {
+		t.Errorf("IsExpired() = true for a session valid for an hour")
+	}
+}
+
+func TestProviderExpiryWindow(t *testing.T) {
+	p := NewProvider(SourceFunc(func() (aws.STSSession, error) {
+		return testSession(time.Minute), nil
+	}))
+	if _, err := p.Retrieve(); err != nil {
+		t.Fatalf("Retrieve(): %s", err)
+	}
+	if !p.IsExpired() {
+		t.Errorf("IsExpired() = false for a session inside the expiry window")
+	}
+}
+
+func TestProviderRetrieveError(t *testing.T) {
+	failed := errors.New("failed")
+	p := NewProvider(SourceFunc(func() (aws.STSSession, error) {
+		return aws.STSSession{}, failed
+	}))
+	creds, err := p.Retrieve()
+	if err != failed {
+		t.Errorf("Retrieve() error = %v, want %v", err, failed)
+	}
+	if creds.ProviderName != PROVIDER_NAME {
+		t.Errorf("ProviderName = %s", creds.ProviderName)
+	}
+}
+
+func TestProviderV2Retrieve(t *testing.T) {
+	session := testSession(time.Hour)
+	p := NewProviderV2(SourceFunc(func() (aws.STSSession, error) {
+		return session, nil
+	}))
+	creds, err := p.Retrieve(context.Background())
+	if err != nil {
+		t.Fatalf("Retrieve(): %s", err)
+	}
+	if creds.AccessKeyID != "AKIDEXAMPLE" || creds.Source != PROVIDER_NAME ||
+		!creds.CanExpire || !creds.Expires.Equal(session.Expiration) {
+		t.Errorf("Retrieve() = %+v", creds)
+	}
+}
+
+func TestProviderV2Cancel(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+	p := NewProviderV2(SourceFunc(func() (aws.STSSession, error) {
+		<-block
+		return testSession(time.Hour), nil
+	}))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := p.Retrieve(ctx)
+	if err != context.DeadlineExceeded {
+		t.Errorf("Retrieve() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestOneLoginSourceStore(t *testing.T) {
+	store := &testStore{session: testSession(time.Hour)}
+	src := &OneLoginSource{Store: store, Profile: "test"}
+	session, err := src.GetSession()
+	if err != nil {
+		t.Fatalf("GetSession(): %s", err)
+	}
+	if session.AccessKeyID != "AKIDEXAMPLE" {
+		t.Errorf("GetSession() = %+v", session)
+	}
+	if store.saved {
+		t.Errorf("GetSession() saved a cached session")
+	}
+}
+
+func TestOneLoginSourceStoreExpiring(t *testing.T) {
+	// inside the expiry window, so we must login again
+	store := &testStore{session: testSession(time.Minute)}
+	src := &OneLoginSource{Store: store, Profile: "test"}
+	_, err := src.GetSession()
+	if err == nil || err.Error() != "OneLoginSource requires a OneLogin client" {
+		t.Errorf("GetSession() error = %v", err)
+	}
+
+	src.ExpiryWindow = 30 * time.Second
+	if _, err = src.GetSession(); err != nil {
+		t.Errorf("GetSession() with a 30s window: %s", err)
+	}
+}
+
+func TestOneLoginSourceCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	p := NewProviderV2(&OneLoginSource{})
+	_, err := p.Retrieve(ctx)
+	if err != context.Canceled {
+		t.Errorf("Retrieve() error = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestOneLoginSourceStorePolicy(t *testing.T) {
+	// cached before the session policy was added, so we must login again
+	store := &testStore{session: testSession(time.Hour)}
+	src := &OneLoginSource{
+		Store:   store,
+		Profile: "test",
+		Policy:  aws.SessionPolicy{PolicyArns: []string{"arn:aws:iam::aws:policy/ReadOnlyAccess"}},
+	}
+	_, err := src.GetSession()
+	if err == nil || err.Error() != "OneLoginSource requires a OneLogin client" {
+		t.Errorf("GetSession() error = %v", err)
+	}
+
+	store.session.PolicyHash = src.Policy.Hash()
+	if _, err = src.GetSession(); err != nil {
+		t.Errorf("GetSession() with the same policy: %s", err)
+	}
+}
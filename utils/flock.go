@@ -0,0 +1,129 @@
+package utils
+
+/*
+ * OneLogin AWS Role
+ * Copyright (c) 2020-2021 Aaron Turner  <aturner at synfin dot net>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+/*
+ * Advisory file locks so that multiple copies of onelogin-aws-role
+ * running at the same time don't clobber each others files.
+ */
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// How often LockFileTimeout() checks if the lock is free
+const LOCK_POLL_INTERVAL = 250 * time.Millisecond
+
+type FileLock struct {
+	f *os.File
+}
+
+// Blocks until we have an exclusive lock on the given lock file
+func LockFile(path string) (*FileLock, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open lock file %s: %s", path, err.Error())
+	}
+	if _, err = lockFile(f, true); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("Unable to lock %s: %s", path, err.Error())
+	}
+	return &FileLock{f: f}, nil
+}
+
+/*
+ * Tries to get an exclusive lock on the given lock file without blocking.
+ * Returns false if another process holds the lock.
+ */
+func TryLockFile(path string) (*FileLock, bool, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, false, fmt.Errorf("Unable to open lock file %s: %s", path, err.Error())
+	}
+	locked, err := lockFile(f, false)
+	if err != nil || !locked {
+		f.Close()
+		if err != nil {
+			return nil, false, fmt.Errorf("Unable to lock %s: %s", path, err.Error())
+		}
+		return nil, false, nil
+	}
+	return &FileLock{f: f}, true, nil
+}
+
+var ErrLockTimeout = errors.New("timed out waiting for lock")
+
+/*
+ * Waits up to timeout for an exclusive lock on the given lock file.
+ * Returns ErrLockTimeout if another process still holds the lock.
+ */
+func LockFileTimeout(path string, timeout time.Duration) (*FileLock, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		lock, ok, err := TryLockFile(path)
+		if err != nil || ok {
+			return lock, err
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("Unable to lock %s: %w", path, ErrLockTimeout)
+		}
+		time.Sleep(LOCK_POLL_INTERVAL)
+	}
+}
+
+// Releases the lock
+func (l *FileLock) Unlock() error {
+	defer l.f.Close()
+	return unlockFile(l.f)
+}
+
+/*
+ * Writes data to a temp file in the same directory and renames it over
+ * path so that readers never see a partially written file.
+ */
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	defer os.Remove(tmpName) // no-op after a successful rename
+
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpName, path)
+}
@@ -0,0 +1,122 @@
+package utils
+
+/*
+ * OneLogin AWS Role
+ * Copyright (c) 2020-2021 Aaron Turner  <aturner at synfin dot net>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+/*
+ * Builds the http.Client used for all our outbound calls to OneLogin and AWS
+ * so that proxies and TLS settings are applied consistently.
+ */
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+type HTTPClientOptions struct {
+	Proxy         string   // proxy URL.  If empty, use $HTTPS_PROXY, etc
+	NoProxy       []string // hosts/domains/CIDRs which bypass the proxy
+	CABundle      string   // path to PEM file of additional CA certs
+	ClientCert    string   // path to PEM client certificate for mTLS
+	ClientKey     string   // path to PEM client key for mTLS
+	MinTLSVersion string   // 1.0, 1.1, 1.2 or 1.3
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// Returns a new http.Client configured via the given options
+func NewHTTPClient(opts HTTPClientOptions) (*http.Client, error) {
+	tlsConfig := &tls.Config{}
+
+	if opts.MinTLSVersion != "" {
+		version, ok := tlsVersions[opts.MinTLSVersion]
+		if !ok {
+			return nil, fmt.Errorf("Invalid min_tls_version: %s", opts.MinTLSVersion)
+		}
+		tlsConfig.MinVersion = version
+	}
+
+	if opts.CABundle != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			// Windows doesn't support SystemCertPool() until go 1.18
+			pool = x509.NewCertPool()
+		}
+		pem, err := ioutil.ReadFile(opts.CABundle)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read ca_bundle: %s", err.Error())
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("No certificates found in ca_bundle: %s", opts.CABundle)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if opts.ClientCert != "" || opts.ClientKey != "" {
+		if opts.ClientCert == "" || opts.ClientKey == "" {
+			return nil, fmt.Errorf("client_cert and client_key must both be specified")
+		}
+		cert, err := tls.LoadX509KeyPair(opts.ClientCert, opts.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to load client certificate: %s", err.Error())
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	proxy, err := proxyFunc(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	transport.Proxy = proxy
+	return &http.Client{Transport: transport}, nil
+}
+
+// Returns the proxy selection function for our http.Transport
+func proxyFunc(opts HTTPClientOptions) (func(*http.Request) (*url.URL, error), error) {
+	config := httpproxy.FromEnvironment()
+	if opts.Proxy != "" {
+		if _, err := url.Parse(opts.Proxy); err != nil {
+			return nil, fmt.Errorf("Invalid proxy URL %s: %s", opts.Proxy, err.Error())
+		}
+		config.HTTPProxy = opts.Proxy
+		config.HTTPSProxy = opts.Proxy
+	}
+	if len(opts.NoProxy) > 0 {
+		config.NoProxy = strings.Join(opts.NoProxy, ",")
+	}
+
+	proxy := config.ProxyFunc()
+	return func(req *http.Request) (*url.URL, error) {
+		return proxy(req.URL)
+	}, nil
+}
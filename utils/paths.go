@@ -0,0 +1,155 @@
+package utils
+
+/*
+ * OneLogin AWS Role
+ * Copyright (c) 2020-2021 Aaron Turner  <aturner at synfin dot net>
+ *
+ * This program is free software: you can redistribute it
+ * and/or modify it under the terms of the GNU General Public License as
+ * published by the Free Software Foundation, either version 3 of the
+ * License, or with the authors permission any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+/*
+ * Where we keep our files.  By default we follow the XDG Base Directory
+ * spec, but $ONELOGIN_AWS_ROLE_HOME puts everything in a single directory.
+ * Older versions kept everything directly in $HOME, so we migrate those files.
+ */
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	APP_NAME    = "onelogin-aws-role"
+	ENV_HOME    = "ONELOGIN_AWS_ROLE_HOME"
+	CONFIG_FILE = "config.yaml"
+	CACHE_FILE  = "saml.cache"
+	KEYS_DIR    = "keys"
+)
+
+// Returns the user's home directory
+func HomeDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return os.Getenv("HOME")
+	}
+	return home
+}
+
+// Expands a leading ~ to the user's home directory
+func ExpandPath(path string) string {
+	if path == "~" {
+		return HomeDir()
+	} else if strings.HasPrefix(path, "~/") || strings.HasPrefix(path, "~"+string(filepath.Separator)) {
+		return filepath.Join(HomeDir(), path[2:])
+	}
+	return path
+}
+
+// Returns the value of the given XDG env var or the default relative to $HOME
+func xdgDir(env string, def string) string {
+	if dir := os.Getenv(env); dir != "" && filepath.IsAbs(dir) {
+		return dir
+	}
+	return filepath.Join(HomeDir(), def)
+}
+
+// Directory holding our config file and file based keyring
+func ConfigDir() string {
+	if dir := os.Getenv(ENV_HOME); dir != "" {
+		return ExpandPath(dir)
+	}
+	return filepath.Join(xdgDir("XDG_CONFIG_HOME", ".config"), APP_NAME)
+}
+
+// Directory holding our cache files
+func CacheDir() string {
+	if dir := os.Getenv(ENV_HOME); dir != "" {
+		return ExpandPath(dir)
+	}
+	return filepath.Join(xdgDir("XDG_CACHE_HOME", ".cache"), APP_NAME)
+}
+
+// Our default config file
+func ConfigFile() string {
+	return filepath.Join(ConfigDir(), CONFIG_FILE)
+}
+
+/*
+ * Returns the cache file for the given namespace so that multiple OneLogin
+ * accounts don't overwrite each other.  An empty namespace is our default cache.
+ */
+func CacheFile(namespace string) string {
+	if namespace == "" {
+		return filepath.Join(CacheDir(), CACHE_FILE)
+	}
+	return filepath.Join(CacheDir(), fmt.Sprintf("saml.%s.cache", namespace))
+}
+
+// Directory for the file based keyring
+func KeysDir() string {
+	return filepath.Join(ConfigDir(), KEYS_DIR)
+}
+
+// Creates the parent directory of the given file if necessary
+func EnsureDir(path string) error {
+	return os.MkdirAll(filepath.Dir(path), 0700)
+}
+
+/*
+ * Moves our cache and keyring files from the locations used by older versions
+ * of onelogin-aws-role.  Files are only moved if they don't already exist in
+ * the new location.  This is idempotent and only costs a couple of stat(2)
+ * calls once the files have been moved, so we run it every time.
+ */
+func MigrateLegacyPaths() {
+	home := HomeDir()
+	if migrate(filepath.Join(home, ".onelogin-aws-role", KEYS_DIR), KeysDir()) {
+		// remove the old parent dir of keys/ if it is now empty
+		os.Remove(filepath.Join(home, ".onelogin-aws-role"))
+	}
+	migrate(filepath.Join(home, ".onelogin-aws-role.cache"), CacheFile(""))
+}
+
+// Moves the config file from the location used by older versions
+func MigrateLegacyConfig() {
+	migrate(filepath.Join(HomeDir(), ".onelogin-aws-role.yaml"), ConfigFile())
+}
+
+// Returns true if old was moved to new
+func migrate(old string, new string) bool {
+	if old == new {
+		return false
+	}
+	if _, err := os.Stat(old); err != nil {
+		return false
+	}
+	if _, err := os.Stat(new); err == nil {
+		log.Debugf("Not migrating %s because %s already exists", old, new)
+		return false
+	}
+	if err := EnsureDir(new); err != nil {
+		log.WithError(err).Warnf("Unable to migrate %s", old)
+		return false
+	}
+	if err := os.Rename(old, new); err != nil {
+		log.WithError(err).Warnf("Unable to migrate %s", old)
+		return false
+	}
+	log.Infof("Moved %s to %s", old, new)
+	return true
+}